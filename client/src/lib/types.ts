@@ -8,6 +8,16 @@ export const enum Tag {
   PLAYER_CLICK,
   PUZZLE_LOAD,
   NEW_PUZZLE,
+  DELTA,
+  SNAPSHOT,
+  PROFILE,
+  CHECK,
+  SETTINGS,
+  COMPLETE,
+  NOT_QUITE,
+  TIMER,
+  SUMMARY,
+  UNDO,
 }
 
 export const enum Source {
@@ -19,9 +29,11 @@ export interface Puzzle {
   attribution: string;
   creators: string;
   downClues: Clue[];
+  flags?: number[];
   grid: string;
   height: number;
   id: string;
+  rebus?: { [index: number]: string };
   title: string;
   width: number;
 }
@@ -57,6 +69,8 @@ export interface Cell {
   isCell: boolean;
   solution: string;
   value: string;
+  // CellFlag bits set on the cell.
+  flags?: number;
 }
 
 export interface Crossword {
@@ -78,7 +92,15 @@ export const enum Key {
   ARROW_RIGHT = 'ArrowRight',
   BACKSPACE = 'Backspace',
   DELETE = 'Delete',
+  END = 'End',
+  ENTER = 'Enter',
+  ESCAPE = 'Escape',
+  HOME = 'Home',
+  INSERT = 'Insert',
   SPACE = ' ',
+  TAB = 'Tab',
+  // Sent for Tab pressed with Shift.
+  SHIFT_TAB = 'Shift+Tab',
 }
 
 export interface Position {
@@ -92,13 +114,104 @@ export interface Player {
   id: string;
   color: Color;
   position: Position;
+  rebusMode: boolean;
+  rebusEntry: string;
+  pencilMode: boolean;
+}
+
+export const enum CellFlag {
+  PREVIOUSLY_INCORRECT = 0x10,
+  INCORRECT = 0x20,
+  REVEALED = 0x40,
+  PENCIL = 0x800,
 }
 
 export interface PlayerUpdate {
   state: string;
+  rebus?: { [index: number]: string };
+  flags?: number[];
   players: { [index: string]: Player };
 }
 
+export interface Snapshot extends PlayerUpdate {
+  seq: number;
+  timer: TimerState;
+}
+
+export interface CellDelta {
+  index: number;
+  value: string;
+  flags: number;
+}
+
+export interface Delta {
+  seq: number;
+  cells?: CellDelta[];
+  players?: { [index: string]: Player };
+  left?: string[];
+}
+
+export interface CheckRequest {
+  action: 'check' | 'reveal';
+  scope: 'cell' | 'word' | 'puzzle';
+}
+
+export interface UndoRequest {
+  action: 'undo' | 'redo';
+  scope: 'player' | 'room';
+}
+
+export interface RoomSettings {
+  host: string;
+  disableReveals: boolean;
+  startTimerOnLoad: boolean;
+  skipFilled: boolean;
+}
+
+export interface TimerRequest {
+  action: 'pause' | 'resume';
+}
+
+export interface TimerState {
+  // Milliseconds.
+  elapsed: number;
+  running: boolean;
+  reason?: 'player' | 'idle' | 'solved';
+}
+
+export interface PlayerStats {
+  letters: number;
+  correctFirstTry: number;
+  wordsCompleted: number;
+  revealed: number;
+}
+
+export interface Contribution extends PlayerStats {
+  playerId: string;
+  name: string;
+  cells: number;
+}
+
+export interface Completion {
+  elapsed: number;
+  solved: boolean;
+  contributions: Contribution[];
+  revealed: number;
+  entries: CellEntry[];
+}
+
+export interface CellEntry {
+  index: number;
+  playerId: string;
+  // RFC 3339 time of the last entry.
+  enteredAt: string;
+}
+
+export interface Profile {
+  name?: string;
+  color?: Color;
+}
+
 export interface Color {
   r: number;
   g: number;
@@ -0,0 +1,150 @@
+package format
+
+type Puzzle struct {
+	ID        string `json:"id"`
+	Version   string `json:"version"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	NumClues  int    `json:"numClues"`
+	Grid      string `json:"grid"`
+	Scrambled bool   `json:"scrambled"`
+	// ScrambledChecksum verifies the key of a scrambled solution.
+	ScrambledChecksum uint16 `json:"scrambledChecksum,omitempty"`
+	// NoSolution is set for puzzles published without a solution. Grid holds
+	// UnknownCell for each cell whose answer is missing.
+	NoSolution  bool   `json:"noSolution,omitempty"`
+	AcrossClues []Clue `json:"acrossClues"`
+	DownClues   []Clue `json:"downClues"`
+	Title       string `json:"title"`
+	Creators    string `json:"creator"`
+	Attribution string `json:"attribution"`
+	Notes       string `json:"notes"`
+	// Flags holds per-cell markings such as circles, indexed like Grid.
+	Flags []CellFlags `json:"flags,omitempty"`
+	// Rebus maps cell indices to their multi-letter solutions.
+	Rebus map[int]string `json:"rebus,omitempty"`
+	// UserRebus maps cell indices to multi-letter entries saved by a solver.
+	UserRebus map[int]string `json:"userRebus,omitempty"`
+	Timer     *PuzzleTimer   `json:"timer,omitempty"`
+}
+
+// UnknownCell marks a Grid cell whose answer was not published.
+const UnknownCell = '?'
+
+// HasSolution reports whether the puzzle's answers are known, so entries can
+// be checked against them.
+func (p *Puzzle) HasSolution() bool {
+	return !p.Scrambled && !p.NoSolution
+}
+
+// CellFlags are the per-cell markings stored in the .puz GEXT section.
+type CellFlags int
+
+const (
+	FlagPreviouslyIncorrect CellFlags = 0x10
+	FlagIncorrect           CellFlags = 0x20
+	FlagRevealed            CellFlags = 0x40
+	FlagCircled             CellFlags = 0x80
+
+	// Flags with no .puz equivalent. FlagShaded marks a shaded cell, and the
+	// bar flags mark a thick border above or to the left of a cell.
+	FlagShaded  CellFlags = 0x100
+	FlagBarTop  CellFlags = 0x200
+	FlagBarLeft CellFlags = 0x400
+)
+
+// PuzzleTimer is a saved solve timer, as stored in the .puz LTIM section.
+type PuzzleTimer struct {
+	Elapsed int  `json:"elapsed"`
+	Stopped bool `json:"stopped"`
+}
+
+type Clue struct {
+	Number    int       `json:"number"`
+	Text      string    `json:"text"`
+	Direction Direction `json:"direction"`
+	Row       int       `json:"row"`
+	Column    int       `json:"column"`
+	Length    int       `json:"length"`
+}
+
+type Direction int
+
+const (
+	Across Direction = iota
+	Down
+)
+
+// Flip returns the other direction.
+func (d Direction) Flip() Direction {
+	return 1 - d
+}
+
+// NumberClues assigns standard crossword numbering to a solution grid, where
+// '.' marks a block, and pairs each numbered entry with the next clue text.
+// Clue texts are ordered by number, with across before down. If texts is nil,
+// the entries are returned without text.
+func NumberClues(grid string, width, height int, texts []string) ([]Clue, []Clue, error) {
+	if len(grid) != width*height {
+		return nil, nil, ErrPuzSize
+	}
+	var acrossClues []Clue
+	var downClues []Clue
+	num := 1
+	clueIndex := 0
+
+	for row := 0; row < height; row++ {
+		for col := 0; col < width; col++ {
+			index := row*width + col
+			if grid[index] == '.' {
+				continue
+			}
+			clue := Clue{
+				Number: num,
+				Row:    row,
+				Column: col,
+			}
+			hasClue := false
+			// Across clue.
+			if (col == 0 || grid[index-1] == '.') && col+1 < width && grid[index+1] != '.' {
+				if texts != nil {
+					if clueIndex >= len(texts) {
+						return nil, nil, ErrPuzClueCount
+					}
+					clue.Text = texts[clueIndex]
+				}
+				clue.Direction = Across
+				for k := col; k < width && grid[row*width+k] != '.'; k++ {
+					clue.Length++
+				}
+				acrossClues = append(acrossClues, clue)
+				clueIndex++
+				hasClue = true
+			}
+			// Down clue.
+			if (row == 0 || grid[index-width] == '.') && row+1 < height && grid[index+width] != '.' {
+				if texts != nil {
+					if clueIndex >= len(texts) {
+						return nil, nil, ErrPuzClueCount
+					}
+					clue.Text = texts[clueIndex]
+				}
+				clue.Length = 0
+				clue.Direction = Down
+				for k := row; k < height && grid[k*width+col] != '.'; k++ {
+					clue.Length++
+				}
+				downClues = append(downClues, clue)
+				clueIndex++
+				hasClue = true
+			}
+			if hasClue {
+				num++
+			}
+		}
+	}
+	if texts != nil && clueIndex != len(texts) {
+		return nil, nil, ErrPuzClueCount
+	}
+	return acrossClues, downClues, nil
+}
@@ -0,0 +1,92 @@
+// Package format reads and writes crossword puzzle files in the .puz, ipuz,
+// JPZ, Across Lite text and xd formats.
+package format
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ErrNoSolution is returned when writing a puzzle without a solution in a
+// format that requires one.
+var ErrNoSolution = errors.New("format: puzzle has no solution")
+
+// Puzzle file formats.
+const (
+	Puz  = "puz"
+	Ipuz = "ipuz"
+	Jpz  = "jpz"
+	Text = "txt"
+	Xd   = "xd"
+)
+
+// FromName guesses the format of a puzzle file from its extension.
+func FromName(name string) string {
+	return strings.ToLower(strings.TrimPrefix(path.Ext(name), "."))
+}
+
+// Supported reports whether puzzles in format can be read.
+func Supported(format string) bool {
+	switch format {
+	case Puz, Ipuz, Jpz, "xml", Text, Xd:
+		return true
+	}
+	return false
+}
+
+// Detect guesses the format of a puzzle file from its contents.
+func Detect(data []byte) string {
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case bytes.Contains(data, []byte(puzMagic)):
+		return Puz
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		return Ipuz
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")), bytes.HasPrefix(trimmed, []byte("<?xml")),
+		bytes.HasPrefix(trimmed, []byte("<crossword")):
+		return Jpz
+	case bytes.HasPrefix(trimmed, []byte(textHeader[:len(textHeader)-1])):
+		return Text
+	}
+	return Xd
+}
+
+// Parse decodes a puzzle file in the given format. If format is empty,
+// it is detected from the contents.
+func Parse(data []byte, id, format string) (Puzzle, error) {
+	if format == "" {
+		format = Detect(data)
+	}
+	switch format {
+	case Puz:
+		return parsePuz(data, id)
+	case Ipuz:
+		return parseIpuz(data, id)
+	case Jpz, "xml":
+		return parseJpz(data, id)
+	case Text:
+		return parseText(data, id)
+	case Xd:
+		return parseXd(data, id)
+	}
+	return Puzzle{}, fmt.Errorf("Unsupported puzzle format %q.", format)
+}
+
+// Write encodes a puzzle in the given format. Only ipuz can leave answers
+// out, so a puzzle without a solution can't be written in the other formats.
+func Write(p Puzzle, format string) ([]byte, error) {
+	switch format {
+	case Puz:
+		return WritePuz(p, nil)
+	case Ipuz:
+		return writeIpuz(p)
+	case Text:
+		return writeText(p)
+	case Xd:
+		return writeXd(p)
+	}
+	return nil, fmt.Errorf("Unsupported puzzle format %q.", format)
+}
@@ -0,0 +1,175 @@
+package format
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testPuzFiles are the checked-in .puz files used as test puzzles.
+var testPuzFiles = []string{"wsj.puz", "wsj210731.puz", "ucs190331.puz"}
+
+func readTestPuz(t *testing.T, name string) Puzzle {
+	t.Helper()
+	data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	puzzle, err := Parse(data, name, Puz)
+	if err != nil {
+		t.Fatalf("%v: %v", name, err)
+	}
+	return puzzle
+}
+
+// comparePuzzles reports the fields that differ between a puzzle and its
+// round trip through a format.
+func comparePuzzles(t *testing.T, name string, want, got Puzzle) {
+	t.Helper()
+	if got.Width != want.Width || got.Height != want.Height || got.Grid != want.Grid {
+		t.Errorf("%v: grid is %dx%d %q, want %dx%d %q", name,
+			got.Width, got.Height, got.Grid, want.Width, want.Height, want.Grid)
+	}
+	if !reflect.DeepEqual(got.AcrossClues, want.AcrossClues) {
+		t.Errorf("%v: across clues are %+v, want %+v", name, got.AcrossClues, want.AcrossClues)
+	}
+	if !reflect.DeepEqual(got.DownClues, want.DownClues) {
+		t.Errorf("%v: down clues are %+v, want %+v", name, got.DownClues, want.DownClues)
+	}
+	if len(got.Rebus) != 0 || len(want.Rebus) != 0 {
+		if !reflect.DeepEqual(got.Rebus, want.Rebus) {
+			t.Errorf("%v: rebus is %v, want %v", name, got.Rebus, want.Rebus)
+		}
+	}
+	if got.Title != want.Title || got.Creators != want.Creators || got.Attribution != want.Attribution {
+		t.Errorf("%v: metadata is %q %q %q, want %q %q %q", name,
+			got.Title, got.Creators, got.Attribution, want.Title, want.Creators, want.Attribution)
+	}
+	if got.Notes != want.Notes {
+		t.Errorf("%v: notes are %q, want %q", name, got.Notes, want.Notes)
+	}
+}
+
+func trimClues(p Puzzle) Puzzle {
+	trim := func(clues []Clue) []Clue {
+		trimmed := make([]Clue, len(clues))
+		for i, clue := range clues {
+			clue.Text = strings.TrimSpace(clue.Text)
+			trimmed[i] = clue
+		}
+		return trimmed
+	}
+	p.AcrossClues = trim(p.AcrossClues)
+	p.DownClues = trim(p.DownClues)
+	return p
+}
+
+// TestFormatRoundTrip writes each test puzzle in every writable format and
+// reads it back.
+func TestFormatRoundTrip(t *testing.T) {
+	for _, file := range testPuzFiles {
+		want := readTestPuz(t, file)
+		for _, format := range []string{Puz, Ipuz, Text, Xd} {
+			name := file + " as " + format
+			if format == Text || format == Xd {
+				// Clues are lines in these formats, so spaces at their ends
+				// are lost.
+				want = trimClues(want)
+			}
+			data, err := Write(want, format)
+			if err != nil {
+				t.Errorf("%v: %v", name, err)
+				continue
+			}
+			if detected := Detect(data); detected != format {
+				t.Errorf("%v: detected as %v", name, detected)
+			}
+			got, err := Parse(data, want.ID, format)
+			if err != nil {
+				t.Errorf("%v: %v", name, err)
+				continue
+			}
+			comparePuzzles(t, name, want, got)
+		}
+	}
+}
+
+// TestTextEmptyClue checks that an empty clue text survives the text
+// format.
+func TestTextEmptyClue(t *testing.T) {
+	want := trimClues(readTestPuz(t, "wsj.puz"))
+	want.AcrossClues[1].Text = ""
+	want.DownClues[len(want.DownClues)-1].Text = ""
+	data, err := writeText(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parseText(data, want.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	comparePuzzles(t, "txt", want, got)
+}
+
+// TestRebusKeys checks that rebus cells written in the text and xd formats
+// are read back as rebus cells, and other cells as themselves, even where
+// those hold digits or symbols.
+func TestRebusKeys(t *testing.T) {
+	base := trimClues(readTestPuz(t, "wsj.puz"))
+	var white []int
+	for i := range base.Grid {
+		if base.Grid[i] != '.' {
+			white = append(white, i)
+		}
+	}
+	tests := []struct {
+		name string
+		// cells are the plain entries to set, by position among the white
+		// cells.
+		cells map[int]byte
+		rebus map[int]string
+		err   bool
+	}{
+		{"rebus", nil, map[int]string{0: "HEART", 1: "DIAMOND", 2: "HEART"}, false},
+		{"digits", map[int]byte{3: '1', 4: '2', 5: '0'}, map[int]string{0: "HEART", 1: "DIAMOND"}, false},
+		{"symbols", map[int]byte{3: '!', 4: '@'}, map[int]string{0: "HEART", 1: "DIAMOND"}, false},
+		{"too many", nil, func() map[int]string {
+			rebus := make(map[int]string)
+			for i := 0; i <= len(rebusKeys); i++ {
+				rebus[i] = "R" + strings.Repeat("E", i+1)
+			}
+			return rebus
+		}(), true},
+	}
+	for _, test := range tests {
+		want := base
+		grid := []byte(base.Grid)
+		for k, c := range test.cells {
+			grid[white[k]] = c
+		}
+		want.Rebus = make(map[int]string)
+		for k, answer := range test.rebus {
+			grid[white[k]] = answer[0]
+			want.Rebus[white[k]] = answer
+		}
+		want.Grid = string(grid)
+		for _, format := range []string{Text, Xd} {
+			name := test.name + " as " + format
+			data, err := Write(want, format)
+			if (err != nil) != test.err {
+				t.Errorf("%v: got error %v, want an error: %v", name, err, test.err)
+			}
+			if err != nil {
+				continue
+			}
+			got, err := Parse(data, want.ID, format)
+			if err != nil {
+				t.Errorf("%v: %v", name, err)
+				continue
+			}
+			comparePuzzles(t, name, want, got)
+		}
+	}
+}
@@ -0,0 +1,454 @@
+package format
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Errors returned when decoding an ipuz file.
+var (
+	ErrIpuzKind     = errors.New("ipuz: not a crossword")
+	ErrIpuzGrid     = errors.New("ipuz: grid does not match dimensions")
+	ErrIpuzSolution = errors.New("ipuz: solution does not match the puzzle grid")
+	ErrIpuzClue     = errors.New("ipuz: clue refers to a missing number")
+)
+
+const (
+	ipuzVersion   = "http://ipuz.org/v2"
+	ipuzCrossword = "http://ipuz.org/crossword#1"
+)
+
+// ipuzFile is the subset of an ipuz v2 crossword read and written here. See
+// http://www.ipuz.org/.
+type ipuzFile struct {
+	Version    string                       `json:"version"`
+	Kind       []string                     `json:"kind"`
+	Dimensions ipuzDimensions               `json:"dimensions"`
+	Title      string                       `json:"title,omitempty"`
+	Author     string                       `json:"author,omitempty"`
+	Copyright  string                       `json:"copyright,omitempty"`
+	Notes      string                       `json:"notes,omitempty"`
+	Intro      string                       `json:"intro,omitempty"`
+	Block      string                       `json:"block,omitempty"`
+	Empty      json.RawMessage              `json:"empty,omitempty"`
+	Styles     map[string]ipuzStyle         `json:"styles,omitempty"`
+	Puzzle     [][]json.RawMessage          `json:"puzzle"`
+	Solution   [][]json.RawMessage          `json:"solution,omitempty"`
+	Clues      map[string][]json.RawMessage `json:"clues"`
+}
+
+type ipuzDimensions struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// ipuzStyle is the subset of ipuz cell styles mapped onto CellFlags.
+type ipuzStyle struct {
+	Shapebg   string `json:"shapebg,omitempty"`
+	Highlight bool   `json:"highlight,omitempty"`
+	Color     string `json:"color,omitempty"`
+}
+
+// ipuzCell is the object form of a cell in the puzzle or solution grids. Its
+// style is either a style object or the name of one of the file's styles.
+type ipuzCell struct {
+	Cell  json.RawMessage `json:"cell,omitempty"`
+	Value string          `json:"value,omitempty"`
+	Style json.RawMessage `json:"style,omitempty"`
+}
+
+// ipuzClue is the object form of a clue.
+type ipuzClue struct {
+	Number json.RawMessage `json:"number"`
+	Clue   string          `json:"clue"`
+}
+
+// ipuzString returns a cell label or value, which may be a JSON string,
+// number or null.
+func ipuzString(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(raw, &n); err != nil {
+		return "", err
+	}
+	return n.String(), nil
+}
+
+// parseIpuzStyle returns a cell's style, looking up named styles in styles.
+// Unknown names have no style.
+func parseIpuzStyle(raw json.RawMessage, styles map[string]ipuzStyle) (*ipuzStyle, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var name string
+	if err := json.Unmarshal(raw, &name); err == nil {
+		if style, ok := styles[name]; ok {
+			return &style, nil
+		}
+		return nil, nil
+	}
+	var style ipuzStyle
+	if err := json.Unmarshal(raw, &style); err != nil {
+		return nil, err
+	}
+	return &style, nil
+}
+
+// parseIpuzCell returns the label and raw style of a puzzle or solution cell.
+func parseIpuzCell(raw json.RawMessage) (string, json.RawMessage, error) {
+	trimmed := strings.TrimSpace(string(raw))
+	if !strings.HasPrefix(trimmed, "{") {
+		label, err := ipuzString(raw)
+		return label, nil, err
+	}
+	var cell ipuzCell
+	if err := json.Unmarshal(raw, &cell); err != nil {
+		return "", nil, err
+	}
+	if cell.Value != "" {
+		return cell.Value, cell.Style, nil
+	}
+	label, err := ipuzString(cell.Cell)
+	return label, cell.Style, err
+}
+
+// parseIpuzClue returns the number and text of a clue in any of the forms
+// [number, text], {"number": number, "clue": text} or a bare text.
+func parseIpuzClue(raw json.RawMessage) (int, string, error) {
+	var pair []json.RawMessage
+	if err := json.Unmarshal(raw, &pair); err == nil {
+		if len(pair) < 2 {
+			return 0, "", fmt.Errorf("ipuz: bad clue %s", raw)
+		}
+		number, err := ipuzString(pair[0])
+		if err != nil {
+			return 0, "", err
+		}
+		var text string
+		if err := json.Unmarshal(pair[1], &text); err != nil {
+			return 0, "", err
+		}
+		n, err := ipuzClueNumber(number)
+		return n, text, err
+	}
+	var clue ipuzClue
+	if err := json.Unmarshal(raw, &clue); err == nil {
+		number, err := ipuzString(clue.Number)
+		if err != nil {
+			return 0, "", err
+		}
+		n, err := ipuzClueNumber(number)
+		return n, clue.Clue, err
+	}
+	return 0, "", fmt.Errorf("ipuz: bad clue %s", raw)
+}
+
+// ipuzClueNumber parses a clue number. Compound numbers such as "3/5" keep
+// their leading number.
+func ipuzClueNumber(number string) (int, error) {
+	n, err := strconv.Atoi(strings.TrimSpace(number))
+	if err != nil {
+		fields := strings.FieldsFunc(number, func(r rune) bool {
+			return r < '0' || r > '9'
+		})
+		if len(fields) > 0 {
+			return strconv.Atoi(fields[0])
+		}
+	}
+	return n, err
+}
+
+// ipuzDirection maps a clue list name such as "Across" or "Down:Down" onto a
+// direction.
+func ipuzDirection(name string) (Direction, bool) {
+	name = strings.ToLower(strings.SplitN(name, ":", 2)[0])
+	switch name {
+	case "across":
+		return Across, true
+	case "down":
+		return Down, true
+	}
+	return Across, false
+}
+
+// wordLength returns the length of the word starting at row, col in dir.
+func wordLength(grid string, width, height, row, col int, dir Direction) int {
+	length := 0
+	for row < height && col < width && grid[row*width+col] != '.' {
+		length++
+		if dir == Across {
+			col++
+		} else {
+			row++
+		}
+	}
+	return length
+}
+
+func parseIpuz(data []byte, id string) (Puzzle, error) {
+	var f ipuzFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return Puzzle{}, err
+	}
+	isCrossword := false
+	for _, kind := range f.Kind {
+		if strings.HasPrefix(kind, "http://ipuz.org/crossword") {
+			isCrossword = true
+		}
+	}
+	if !isCrossword {
+		return Puzzle{}, ErrIpuzKind
+	}
+
+	width := f.Dimensions.Width
+	height := f.Dimensions.Height
+	if width <= 0 || height <= 0 || len(f.Puzzle) != height {
+		return Puzzle{}, ErrIpuzGrid
+	}
+	// The solution may be left out of puzzles published without one.
+	if len(f.Solution) != 0 && len(f.Solution) != height {
+		return Puzzle{}, ErrIpuzSolution
+	}
+	block := f.Block
+	if block == "" {
+		block = "#"
+	}
+
+	n := width * height
+	grid := make([]byte, n)
+	flags := make([]CellFlags, n)
+	hasFlags := false
+	rebus := make(map[int]string)
+	numbers := make(map[int]int)
+	noSolution := false
+	for row := 0; row < height; row++ {
+		if len(f.Puzzle[row]) != width || (f.Solution != nil && len(f.Solution[row]) != width) {
+			return Puzzle{}, ErrIpuzGrid
+		}
+		for col := 0; col < width; col++ {
+			index := row*width + col
+			label, rawStyle, err := parseIpuzCell(f.Puzzle[row][col])
+			if err != nil {
+				return Puzzle{}, err
+			}
+			if string(f.Puzzle[row][col]) == "null" || label == block {
+				grid[index] = '.'
+				continue
+			}
+			if number, err := strconv.Atoi(label); err == nil && number > 0 {
+				numbers[number] = index
+			}
+			style, err := parseIpuzStyle(rawStyle, f.Styles)
+			if err != nil {
+				return Puzzle{}, err
+			}
+			if style != nil {
+				if style.Shapebg == "circle" {
+					flags[index] |= FlagCircled
+					hasFlags = true
+				}
+				if style.Highlight || style.Color != "" {
+					flags[index] |= FlagShaded
+					hasFlags = true
+				}
+			}
+
+			value := ""
+			if f.Solution != nil {
+				if value, _, err = parseIpuzCell(f.Solution[row][col]); err != nil {
+					return Puzzle{}, err
+				}
+			}
+			value = strings.ToUpper(value)
+			if value == block {
+				return Puzzle{}, ErrIpuzSolution
+			}
+			if value == "" {
+				// Puzzles without a published solution can still be played,
+				// but can't be checked.
+				value = string(UnknownCell)
+				noSolution = true
+			}
+			grid[index] = value[0]
+			if len(value) > 1 {
+				rebus[index] = value
+			}
+		}
+	}
+
+	var acrossClues []Clue
+	var downClues []Clue
+	for name, list := range f.Clues {
+		dir, ok := ipuzDirection(name)
+		if !ok {
+			continue
+		}
+		for _, raw := range list {
+			number, text, err := parseIpuzClue(raw)
+			if err != nil {
+				return Puzzle{}, err
+			}
+			index, ok := numbers[number]
+			if !ok {
+				return Puzzle{}, fmt.Errorf("%w: %d", ErrIpuzClue, number)
+			}
+			clue := Clue{
+				Number:    number,
+				Text:      text,
+				Direction: dir,
+				Row:       index / width,
+				Column:    index % width,
+				Length:    wordLength(string(grid), width, height, index/width, index%width, dir),
+			}
+			if dir == Across {
+				acrossClues = append(acrossClues, clue)
+			} else {
+				downClues = append(downClues, clue)
+			}
+		}
+	}
+
+	notes := f.Notes
+	if notes == "" {
+		notes = f.Intro
+	}
+	puzzle := Puzzle{
+		ID:          id,
+		Width:       width,
+		Height:      height,
+		NumClues:    len(acrossClues) + len(downClues),
+		Grid:        string(grid),
+		NoSolution:  noSolution,
+		AcrossClues: acrossClues,
+		DownClues:   downClues,
+		Title:       f.Title,
+		Creators:    f.Author,
+		Attribution: f.Copyright,
+		Notes:       notes,
+	}
+	if hasFlags {
+		puzzle.Flags = flags
+	}
+	if len(rebus) > 0 {
+		puzzle.Rebus = rebus
+	}
+	return puzzle, nil
+}
+
+func writeIpuz(p Puzzle) ([]byte, error) {
+	if len(p.Grid) != p.Width*p.Height {
+		return nil, ErrIpuzGrid
+	}
+	numbers := make(map[int]int)
+	for _, clues := range [][]Clue{p.AcrossClues, p.DownClues} {
+		for _, clue := range clues {
+			numbers[clue.Row*p.Width+clue.Column] = clue.Number
+		}
+	}
+
+	block, _ := json.Marshal("#")
+	hasSolution := false
+	puzzle := make([][]json.RawMessage, p.Height)
+	solution := make([][]json.RawMessage, p.Height)
+	for row := 0; row < p.Height; row++ {
+		puzzle[row] = make([]json.RawMessage, p.Width)
+		solution[row] = make([]json.RawMessage, p.Width)
+		for col := 0; col < p.Width; col++ {
+			index := row*p.Width + col
+			if p.Grid[index] == '.' {
+				puzzle[row][col] = block
+				solution[row][col] = block
+				continue
+			}
+
+			var label interface{} = 0
+			if number, ok := numbers[index]; ok {
+				label = number
+			}
+			var style *ipuzStyle
+			if index < len(p.Flags) {
+				if p.Flags[index]&FlagCircled != 0 {
+					style = &ipuzStyle{Shapebg: "circle"}
+				}
+				if p.Flags[index]&FlagShaded != 0 {
+					if style == nil {
+						style = &ipuzStyle{}
+					}
+					style.Highlight = true
+				}
+			}
+			var cell interface{} = label
+			if style != nil {
+				encoded, err := json.Marshal(label)
+				if err != nil {
+					return nil, err
+				}
+				encodedStyle, err := json.Marshal(style)
+				if err != nil {
+					return nil, err
+				}
+				cell = ipuzCell{Cell: encoded, Style: encodedStyle}
+			}
+			encoded, err := json.Marshal(cell)
+			if err != nil {
+				return nil, err
+			}
+			puzzle[row][col] = encoded
+
+			var value interface{} = p.Grid[index : index+1]
+			if answer, ok := p.Rebus[index]; ok {
+				value = answer
+			}
+			if p.NoSolution && p.Grid[index] == UnknownCell {
+				value = nil
+			} else {
+				hasSolution = true
+			}
+			if solution[row][col], err = json.Marshal(value); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	clues := map[string][]json.RawMessage{}
+	for name, list := range map[string][]Clue{"Across": p.AcrossClues, "Down": p.DownClues} {
+		clues[name] = make([]json.RawMessage, 0, len(list))
+		for _, clue := range list {
+			encoded, err := json.Marshal([]interface{}{clue.Number, clue.Text})
+			if err != nil {
+				return nil, err
+			}
+			clues[name] = append(clues[name], encoded)
+		}
+	}
+
+	if !hasSolution {
+		// Unknown answers are left out rather than written as letters.
+		solution = nil
+	}
+	empty, _ := json.Marshal(0)
+	f := ipuzFile{
+		Version:    ipuzVersion,
+		Kind:       []string{ipuzCrossword},
+		Dimensions: ipuzDimensions{p.Width, p.Height},
+		Title:      p.Title,
+		Author:     p.Creators,
+		Copyright:  p.Attribution,
+		Notes:      p.Notes,
+		Block:      "#",
+		Empty:      empty,
+		Puzzle:     puzzle,
+		Solution:   solution,
+		Clues:      clues,
+	}
+	return json.MarshalIndent(f, "", "  ")
+}
@@ -0,0 +1,111 @@
+package format
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// compoundIpuz has clues numbered "1/3" and "3/1" for a word spanning two
+// entries, in both the pair and object forms.
+const compoundIpuz = `{
+	"version": "http://ipuz.org/v2",
+	"kind": ["http://ipuz.org/crossword#1"],
+	"dimensions": {"width": 3, "height": 3},
+	"puzzle": [[1, 2, 3], [4, 0, 0], [5, 0, 0]],
+	"solution": [["C", "A", "T"], ["A", "G", "O"], ["R", "E", "D"]],
+	"clues": {
+		"Across": [["1/3", "Pet, with 3-Down"], [4, "Long ___"], {"number": 5, "clue": "Color"}],
+		"Down": [[1, "Vehicle"], [2, "Years"], {"number": "3/1", "clue": "See 1-Across"}]
+	}
+}`
+
+func TestIpuzCompoundClue(t *testing.T) {
+	puzzle, err := parseIpuz([]byte(compoundIpuz), "compound")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := puzzle.AcrossClues[0].Number; n != 1 {
+		t.Errorf("1-Across is numbered %d, want 1", n)
+	}
+	if n := puzzle.DownClues[2].Number; n != 3 {
+		t.Errorf("3-Down is numbered %d, want 3", n)
+	}
+	if clue := puzzle.DownClues[2]; clue.Column != 2 || clue.Length != 3 {
+		t.Errorf("3-Down is at column %d with length %d, want 2 and 3", clue.Column, clue.Length)
+	}
+
+	data, err := writeIpuz(puzzle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parseIpuz(data, puzzle.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	comparePuzzles(t, "compound", puzzle, got)
+}
+
+// styledIpuz styles its cells by name, by object and with an unknown name,
+// and has no solution.
+const styledIpuz = `{
+	"version": "http://ipuz.org/v2",
+	"kind": ["http://ipuz.org/crossword#1"],
+	"dimensions": {"width": 3, "height": 1},
+	"styles": {"circled": {"shapebg": "circle"}},
+	"puzzle": [[{"cell": 1, "style": "circled"}, {"cell": 0, "style": {"highlight": true}}, {"cell": 0, "style": "unknown"}]],
+	"clues": {"Across": [[1, "Pet"]]}
+}`
+
+func TestIpuzStylesWithoutSolution(t *testing.T) {
+	puzzle, err := parseIpuz([]byte(styledIpuz), "styled")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []CellFlags{FlagCircled, FlagShaded, 0}
+	if !reflect.DeepEqual(puzzle.Flags, want) {
+		t.Errorf("flags are %v, want %v", puzzle.Flags, want)
+	}
+	if !puzzle.NoSolution || puzzle.HasSolution() {
+		t.Error("puzzle without a solution has one")
+	}
+	if grid := strings.Repeat(string(UnknownCell), 3); puzzle.Grid != grid {
+		t.Errorf("grid is %q, want %q", puzzle.Grid, grid)
+	}
+
+	// The unknown answers are left out of the written file.
+	data, err := writeIpuz(puzzle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), `"solution"`) {
+		t.Errorf("written puzzle has a solution: %s", data)
+	}
+	got, err := parseIpuz(data, puzzle.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	comparePuzzles(t, "styled", puzzle, got)
+	if !got.NoSolution || !reflect.DeepEqual(got.Flags, want) {
+		t.Errorf("round trip lost NoSolution or flags: %v %v", got.NoSolution, got.Flags)
+	}
+}
+
+// TestJpzToIpuz checks that a JPZ without a solution can be saved as ipuz.
+func TestJpzToIpuz(t *testing.T) {
+	puzzle, err := parseJpz([]byte(unsolvedJpz), "unsolved")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := Write(puzzle, Ipuz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Parse(data, puzzle.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.NoSolution || got.Grid != puzzle.Grid {
+		t.Errorf("grid is %q with NoSolution %v, want %q", got.Grid, got.NoSolution, puzzle.Grid)
+	}
+}
@@ -0,0 +1,345 @@
+package format
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Errors returned when decoding a JPZ file.
+var (
+	ErrJpzGrid = errors.New("jpz: invalid grid")
+	ErrJpzWord = errors.New("jpz: clue refers to an invalid word")
+)
+
+// jpzFile is the subset of a Crossword Compiler JPZ file read here. The root
+// element is usually crossword-compiler-applet or crossword-compiler.
+type jpzFile struct {
+	Puzzle jpzPuzzle `xml:"rectangular-puzzle"`
+}
+
+type jpzPuzzle struct {
+	Metadata  jpzMetadata  `xml:"metadata"`
+	Crossword jpzCrossword `xml:"crossword"`
+}
+
+type jpzMetadata struct {
+	Title       jpzText `xml:"title"`
+	Creator     jpzText `xml:"creator"`
+	Copyright   jpzText `xml:"copyright"`
+	Description jpzText `xml:"description"`
+}
+
+type jpzCrossword struct {
+	Grid  jpzGrid    `xml:"grid"`
+	Words []jpzWord  `xml:"word"`
+	Clues []jpzClues `xml:"clues"`
+}
+
+type jpzGrid struct {
+	Width  int       `xml:"width,attr"`
+	Height int       `xml:"height,attr"`
+	Cells  []jpzCell `xml:"cell"`
+}
+
+type jpzCell struct {
+	X               int    `xml:"x,attr"`
+	Y               int    `xml:"y,attr"`
+	Type            string `xml:"type,attr"`
+	Solution        string `xml:"solution,attr"`
+	Number          string `xml:"number,attr"`
+	BackgroundShape string `xml:"background-shape,attr"`
+	BackgroundColor string `xml:"background-color,attr"`
+	TopBar          bool   `xml:"top-bar,attr"`
+	BottomBar       bool   `xml:"bottom-bar,attr"`
+	LeftBar         bool   `xml:"left-bar,attr"`
+	RightBar        bool   `xml:"right-bar,attr"`
+}
+
+// jpzWord lists the cells of a word, either as x and y ranges such as
+// x="1-5" y="3" or as child cells elements.
+type jpzWord struct {
+	ID    string         `xml:"id,attr"`
+	X     string         `xml:"x,attr"`
+	Y     string         `xml:"y,attr"`
+	Cells []jpzWordCells `xml:"cells"`
+}
+
+type jpzWordCells struct {
+	X string `xml:"x,attr"`
+	Y string `xml:"y,attr"`
+}
+
+type jpzClues struct {
+	Title jpzText   `xml:"title"`
+	Clues []jpzClue `xml:"clue"`
+}
+
+type jpzClue struct {
+	Word   string `xml:"word,attr"`
+	Number string `xml:"number,attr"`
+	Text   string `xml:",innerxml"`
+}
+
+// jpzText is an element whose content may contain formatting markup.
+type jpzText struct {
+	Inner string `xml:",innerxml"`
+}
+
+func (t jpzText) String() string {
+	return jpzPlainText(t.Inner)
+}
+
+// jpzPlainText strips markup from the content of an element.
+func jpzPlainText(inner string) string {
+	var b strings.Builder
+	depth := 0
+	for _, r := range inner {
+		switch {
+		case r == '<':
+			depth++
+		case r == '>' && depth > 0:
+			depth--
+		case depth == 0:
+			b.WriteRune(r)
+		}
+	}
+	return strings.TrimSpace(html.UnescapeString(b.String()))
+}
+
+// jpzRange expands a coordinate such as "3" or "1-5" into its values, which
+// must lie in [1, max].
+func jpzRange(s string, max int) ([]int, error) {
+	parts := strings.SplitN(s, "-", 2)
+	start, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return nil, err
+	}
+	end := start
+	if len(parts) == 2 {
+		if end, err = strconv.Atoi(strings.TrimSpace(parts[1])); err != nil {
+			return nil, err
+		}
+	}
+	if start < 1 || end < 1 || start > max || end > max {
+		return nil, ErrJpzGrid
+	}
+	var values []int
+	if start <= end {
+		for v := start; v <= end; v++ {
+			values = append(values, v)
+		}
+	} else {
+		for v := start; v >= end; v-- {
+			values = append(values, v)
+		}
+	}
+	return values, nil
+}
+
+// cells returns the zero-based grid indices of the word, in order.
+func (w jpzWord) cells(width, height int) ([]int, error) {
+	ranges := w.Cells
+	if w.X != "" {
+		ranges = append([]jpzWordCells{{w.X, w.Y}}, ranges...)
+	}
+	var cells []int
+	for _, r := range ranges {
+		xs, err := jpzRange(r.X, width)
+		if err != nil {
+			return nil, err
+		}
+		ys, err := jpzRange(r.Y, height)
+		if err != nil {
+			return nil, err
+		}
+		for _, y := range ys {
+			for _, x := range xs {
+				cells = append(cells, (y-1)*width+x-1)
+			}
+		}
+	}
+	return cells, nil
+}
+
+// unzipJpz returns the first file of a zipped JPZ archive.
+func unzipJpz(data []byte) ([]byte, error) {
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range r.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return io.ReadAll(rc)
+	}
+	return nil, errors.New("jpz: empty archive")
+}
+
+// jpzShaded reports whether a background color is visibly shaded.
+func jpzShaded(color string) bool {
+	color = strings.ToUpper(strings.TrimSpace(color))
+	return color != "" && color != "#FFFFFF" && color != "#FFF" && color != "WHITE"
+}
+
+func parseJpz(data []byte, id string) (Puzzle, error) {
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		var err error
+		if data, err = unzipJpz(data); err != nil {
+			return Puzzle{}, err
+		}
+	}
+
+	var f jpzFile
+	if err := xml.Unmarshal(data, &f); err != nil {
+		return Puzzle{}, err
+	}
+	p := f.Puzzle
+	if p.Crossword.Grid.Width == 0 {
+		// The rectangular-puzzle element may be the root.
+		if err := xml.Unmarshal(data, &p); err != nil {
+			return Puzzle{}, err
+		}
+	}
+
+	width := p.Crossword.Grid.Width
+	height := p.Crossword.Grid.Height
+	if width <= 0 || height <= 0 {
+		return Puzzle{}, ErrJpzGrid
+	}
+	n := width * height
+	grid := bytes.Repeat([]byte{'.'}, n)
+	flags := make([]CellFlags, n)
+	rebus := make(map[int]string)
+	noSolution := false
+	for _, cell := range p.Crossword.Grid.Cells {
+		if cell.X < 1 || cell.Y < 1 || cell.X > width || cell.Y > height {
+			return Puzzle{}, ErrJpzGrid
+		}
+		index := (cell.Y-1)*width + cell.X - 1
+		if cell.Type == "block" || cell.Type == "void" || cell.Type == "clue" {
+			continue
+		}
+		solution := strings.ToUpper(cell.Solution)
+		if solution == "" {
+			// Puzzles without a published solution can still be played,
+			// but can't be checked.
+			solution = string(UnknownCell)
+			noSolution = true
+		}
+		grid[index] = solution[0]
+		if len(solution) > 1 {
+			rebus[index] = solution
+		}
+
+		if cell.BackgroundShape == "circle" {
+			flags[index] |= FlagCircled
+		}
+		if jpzShaded(cell.BackgroundColor) {
+			flags[index] |= FlagShaded
+		}
+		if cell.TopBar {
+			flags[index] |= FlagBarTop
+		}
+		if cell.LeftBar {
+			flags[index] |= FlagBarLeft
+		}
+		if cell.BottomBar && cell.Y < height {
+			flags[index+width] |= FlagBarTop
+		}
+		if cell.RightBar && cell.X < width {
+			flags[index+1] |= FlagBarLeft
+		}
+	}
+
+	words := make(map[string][]int)
+	for _, word := range p.Crossword.Words {
+		cells, err := word.cells(width, height)
+		if err != nil {
+			return Puzzle{}, fmt.Errorf("jpz: word %s: %w", word.ID, err)
+		}
+		words[word.ID] = cells
+	}
+
+	var acrossClues []Clue
+	var downClues []Clue
+	for _, list := range p.Crossword.Clues {
+		title := strings.ToLower(list.Title.String())
+		for _, c := range list.Clues {
+			cells, ok := words[c.Word]
+			if !ok || len(cells) == 0 {
+				return Puzzle{}, fmt.Errorf("%w: %q", ErrJpzWord, c.Word)
+			}
+			// Words are not always numbered by the standard scheme, so
+			// position and length come from the word's own cells.
+			dir := Down
+			switch {
+			case strings.HasPrefix(title, "across"):
+				dir = Across
+			case strings.HasPrefix(title, "down"):
+			case len(cells) == 1 || cells[1] == cells[0]+1:
+				dir = Across
+			}
+			number, err := strconv.Atoi(strings.TrimSpace(c.Number))
+			if err != nil {
+				// Compound numbers such as "12/14" keep their leading number.
+				fields := strings.FieldsFunc(c.Number, func(r rune) bool {
+					return r < '0' || r > '9'
+				})
+				if len(fields) > 0 {
+					number, _ = strconv.Atoi(fields[0])
+				}
+			}
+			clue := Clue{
+				Number:    number,
+				Text:      jpzPlainText(c.Text),
+				Direction: dir,
+				Row:       cells[0] / width,
+				Column:    cells[0] % width,
+				Length:    len(cells),
+			}
+			if dir == Across {
+				acrossClues = append(acrossClues, clue)
+			} else {
+				downClues = append(downClues, clue)
+			}
+		}
+	}
+
+	puzzle := Puzzle{
+		ID:          id,
+		Width:       width,
+		Height:      height,
+		NumClues:    len(acrossClues) + len(downClues),
+		Grid:        string(grid),
+		NoSolution:  noSolution,
+		AcrossClues: acrossClues,
+		DownClues:   downClues,
+		Title:       p.Metadata.Title.String(),
+		Creators:    p.Metadata.Creator.String(),
+		Attribution: p.Metadata.Copyright.String(),
+		Notes:       p.Metadata.Description.String(),
+	}
+	for _, flag := range flags {
+		if flag != 0 {
+			puzzle.Flags = flags
+			break
+		}
+	}
+	if len(rebus) > 0 {
+		puzzle.Rebus = rebus
+	}
+	return puzzle, nil
+}
@@ -0,0 +1,68 @@
+package format
+
+import (
+	"reflect"
+	"testing"
+)
+
+const unsolvedJpz = `<?xml version="1.0" encoding="UTF-8"?>
+<crossword-compiler xmlns="http://crossword.info/xml/crossword-compiler">
+<rectangular-puzzle xmlns="http://crossword.info/xml/rectangular-puzzle">
+<metadata><title>Unsolved</title></metadata>
+<crossword>
+<grid width="2" height="1">
+<cell x="1" y="1" number="1"/>
+<cell x="2" y="1"/>
+</grid>
+<word id="1" x="1-2" y="1"/>
+<clues><title><b>Across</b></title><clue word="1" number="1">Two letters</clue></clues>
+</crossword>
+</rectangular-puzzle>
+</crossword-compiler>`
+
+// TestJpzNoSolution checks that a JPZ without solution letters is flagged as
+// having no solution.
+func TestJpzNoSolution(t *testing.T) {
+	puzzle, err := parseJpz([]byte(unsolvedJpz), "unsolved")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !puzzle.NoSolution {
+		t.Fatal("puzzle without solution letters has NoSolution unset")
+	}
+	if want := string([]byte{UnknownCell, UnknownCell}); puzzle.Grid != want {
+		t.Errorf("grid is %q, want %q", puzzle.Grid, want)
+	}
+	// The unknown cells must not be exported as answers.
+	for _, format := range []string{Puz, Text, Xd} {
+		if _, err := Write(puzzle, format); err != ErrNoSolution {
+			t.Errorf("%v: got %v, want %v", format, err, ErrNoSolution)
+		}
+	}
+}
+
+func TestJpzRange(t *testing.T) {
+	tests := []struct {
+		s    string
+		max  int
+		want []int
+		ok   bool
+	}{
+		{"3", 5, []int{3}, true},
+		{"1-5", 5, []int{1, 2, 3, 4, 5}, true},
+		{"4-2", 5, []int{4, 3, 2}, true},
+		{" 2 - 3 ", 5, []int{2, 3}, true},
+		{"0", 5, nil, false},
+		{"1-6", 5, nil, false},
+		{"1-1000000000", 15, nil, false},
+		{"1-9223372036854775807", 15, nil, false},
+		{"9223372036854775807", 15, nil, false},
+		{"a-3", 5, nil, false},
+	}
+	for _, test := range tests {
+		got, err := jpzRange(test.s, test.max)
+		if (err == nil) != test.ok || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("jpzRange(%q, %d) = %v, %v", test.s, test.max, got, err)
+		}
+	}
+}
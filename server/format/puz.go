@@ -0,0 +1,510 @@
+package format
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tmngo/crossword-server/util"
+)
+
+// Layout of the fixed-size .puz header, relative to the start of the file
+// checksum. See https://code.google.com/archive/p/puz/wikis/FileFormat.wiki.
+const (
+	puzOffsetChecksum          = 0x00
+	puzOffsetMagic             = 0x02
+	puzOffsetCIBChecksum       = 0x0E
+	puzOffsetMaskedLow         = 0x10
+	puzOffsetMaskedHigh        = 0x14
+	puzOffsetVersion           = 0x18
+	puzOffsetScrambledChecksum = 0x1E
+	puzOffsetWidth             = 0x2C
+	puzOffsetHeight            = 0x2D
+	puzOffsetNumClues          = 0x2E
+	puzOffsetPuzzleType        = 0x30
+	puzOffsetScrambledTag      = 0x32
+	puzHeaderSize              = 0x34
+
+	puzMagic = "ACROSS&DOWN\x00"
+
+	// puzScrambledTag marks a solution that has been scrambled with a key.
+	puzScrambledTag = 0x0004
+)
+
+// Errors returned when decoding a malformed .puz file.
+var (
+	ErrPuzMagic     = errors.New("puz: missing ACROSS&DOWN magic")
+	ErrPuzTruncated = errors.New("puz: file is truncated")
+	ErrPuzVersion   = errors.New("puz: unrecognized version string")
+	ErrPuzSize      = errors.New("puz: invalid grid dimensions")
+	ErrPuzClueCount = errors.New("puz: clue count does not match grid")
+)
+
+// PuzChecksumError reports a checksum stored in a .puz file that does not
+// match the checksum computed from its contents.
+type PuzChecksumError struct {
+	Section  string
+	Expected uint16
+	Actual   uint16
+}
+
+func (e *PuzChecksumError) Error() string {
+	return fmt.Sprintf("puz: %s checksum mismatch: stored 0x%04x, computed 0x%04x",
+		e.Section, e.Expected, e.Actual)
+}
+
+// puzFile holds the raw sections of a decoded .puz file.
+type puzFile struct {
+	version           string
+	width             int
+	height            int
+	numClues          int
+	puzzleType        uint16
+	scrambledTag      uint16
+	scrambledChecksum uint16
+	solution          []byte
+	state             []byte
+	title             []byte
+	author            []byte
+	copyright         []byte
+	clues             [][]byte
+	notes             []byte
+	sections          []puzSection
+}
+
+// puzReader reads sequential sections from a .puz body.
+type puzReader struct {
+	data   []byte
+	offset int
+}
+
+func (r *puzReader) next(n int) ([]byte, error) {
+	if n < 0 || r.offset+n > len(r.data) {
+		return nil, ErrPuzTruncated
+	}
+	b := r.data[r.offset : r.offset+n]
+	r.offset += n
+	return b, nil
+}
+
+// nextString returns the bytes up to the next null terminator and consumes
+// the terminator.
+func (r *puzReader) nextString() ([]byte, error) {
+	end := bytes.IndexByte(r.data[r.offset:], 0)
+	if end == -1 {
+		return nil, ErrPuzTruncated
+	}
+	b := r.data[r.offset : r.offset+end]
+	r.offset += end + 1
+	return b, nil
+}
+
+// puzChecksum continues the .puz checksum of a region from cksum.
+func puzChecksum(data []byte, cksum uint16) uint16 {
+	for _, b := range data {
+		if cksum&1 != 0 {
+			cksum = (cksum >> 1) + 0x8000
+		} else {
+			cksum >>= 1
+		}
+		cksum += uint16(b)
+	}
+	return cksum
+}
+
+// puzStringChecksum continues the checksum of a non-empty string, including
+// its null terminator. Empty strings are skipped.
+func puzStringChecksum(s []byte, cksum uint16) uint16 {
+	if len(s) == 0 {
+		return cksum
+	}
+	cksum = puzChecksum(s, cksum)
+	return puzChecksum([]byte{0}, cksum)
+}
+
+// stringsChecksum computes the checksum of the title, author, copyright,
+// clue and notes strings, starting from cksum.
+func (f *puzFile) stringsChecksum(cksum uint16) uint16 {
+	cksum = puzStringChecksum(f.title, cksum)
+	cksum = puzStringChecksum(f.author, cksum)
+	cksum = puzStringChecksum(f.copyright, cksum)
+	for _, clue := range f.clues {
+		cksum = puzChecksum(clue, cksum)
+	}
+	if f.versionAtLeast(1, 3) {
+		cksum = puzStringChecksum(f.notes, cksum)
+	}
+	return cksum
+}
+
+// header returns the checksummed portion of the header (CIB).
+func (f *puzFile) header() []byte {
+	cib := make([]byte, puzHeaderSize-puzOffsetWidth)
+	cib[0] = byte(f.width)
+	cib[1] = byte(f.height)
+	binary.LittleEndian.PutUint16(cib[2:], uint16(f.numClues))
+	binary.LittleEndian.PutUint16(cib[4:], f.puzzleType)
+	binary.LittleEndian.PutUint16(cib[6:], f.scrambledTag)
+	return cib
+}
+
+// checksums returns the CIB, global, and masked low and high checksums.
+func (f *puzFile) checksums() (cib, global uint16, low, high [4]byte) {
+	cib = puzChecksum(f.header(), 0)
+	global = puzChecksum(f.solution, cib)
+	global = puzChecksum(f.state, global)
+	global = f.stringsChecksum(global)
+
+	parts := [4]uint16{
+		cib,
+		puzChecksum(f.solution, 0),
+		puzChecksum(f.state, 0),
+		f.stringsChecksum(0),
+	}
+	lowMask := []byte("ICHE")
+	highMask := []byte("ATED")
+	for i, c := range parts {
+		low[i] = lowMask[i] ^ byte(c)
+		high[i] = highMask[i] ^ byte(c>>8)
+	}
+	return
+}
+
+// versionAtLeast reports whether the file version is at least major.minor.
+func (f *puzFile) versionAtLeast(major, minor int) bool {
+	maj, min, err := parsePuzVersion(f.version)
+	if err != nil {
+		return false
+	}
+	return maj > major || (maj == major && min >= minor)
+}
+
+// parsePuzVersion parses a version string such as "1.3" or "1.2c".
+func parsePuzVersion(version string) (int, int, error) {
+	parts := strings.SplitN(version, ".", 2)
+	if len(parts) != 2 {
+		return 0, 0, ErrPuzVersion
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, ErrPuzVersion
+	}
+	digits := strings.TrimRightFunc(parts[1], func(r rune) bool {
+		return r < '0' || r > '9'
+	})
+	minor, err := strconv.Atoi(digits)
+	if err != nil {
+		return 0, 0, ErrPuzVersion
+	}
+	return major, minor, nil
+}
+
+// decodePuz decodes and verifies the sections of a .puz file.
+func decodePuz(data []byte) (*puzFile, error) {
+	// Some files carry a preamble before the checksum, so locate the magic.
+	magic := bytes.Index(data, []byte(puzMagic))
+	if magic < puzOffsetMagic {
+		if len(data) < puzHeaderSize {
+			return nil, ErrPuzTruncated
+		}
+		return nil, ErrPuzMagic
+	}
+	data = data[magic-puzOffsetMagic:]
+	if len(data) < puzHeaderSize {
+		return nil, ErrPuzTruncated
+	}
+
+	f := &puzFile{
+		version:           string(bytes.TrimRight(data[puzOffsetVersion:puzOffsetVersion+4], "\x00")),
+		width:             int(data[puzOffsetWidth]),
+		height:            int(data[puzOffsetHeight]),
+		numClues:          int(binary.LittleEndian.Uint16(data[puzOffsetNumClues:])),
+		puzzleType:        binary.LittleEndian.Uint16(data[puzOffsetPuzzleType:]),
+		scrambledTag:      binary.LittleEndian.Uint16(data[puzOffsetScrambledTag:]),
+		scrambledChecksum: binary.LittleEndian.Uint16(data[puzOffsetScrambledChecksum:]),
+	}
+	if _, _, err := parsePuzVersion(f.version); err != nil {
+		return nil, err
+	}
+	if f.width == 0 || f.height == 0 {
+		return nil, ErrPuzSize
+	}
+
+	r := &puzReader{data: data, offset: puzHeaderSize}
+	n := f.width * f.height
+	var err error
+	if f.solution, err = r.next(n); err != nil {
+		return nil, err
+	}
+	if f.state, err = r.next(n); err != nil {
+		return nil, err
+	}
+	if f.title, err = r.nextString(); err != nil {
+		return nil, err
+	}
+	if f.author, err = r.nextString(); err != nil {
+		return nil, err
+	}
+	if f.copyright, err = r.nextString(); err != nil {
+		return nil, err
+	}
+	f.clues = make([][]byte, f.numClues)
+	for i := range f.clues {
+		if f.clues[i], err = r.nextString(); err != nil {
+			return nil, err
+		}
+	}
+	if f.notes, err = r.nextString(); err != nil {
+		return nil, err
+	}
+	if f.sections, err = decodePuzSections(r, n); err != nil {
+		return nil, err
+	}
+
+	cib, global, low, high := f.checksums()
+	if stored := binary.LittleEndian.Uint16(data[puzOffsetCIBChecksum:]); stored != cib {
+		return nil, &PuzChecksumError{"CIB", stored, cib}
+	}
+	if stored := binary.LittleEndian.Uint16(data[puzOffsetChecksum:]); stored != global {
+		return nil, &PuzChecksumError{"global", stored, global}
+	}
+	if stored := data[puzOffsetMaskedLow : puzOffsetMaskedLow+4]; !bytes.Equal(stored, low[:]) {
+		return nil, &PuzChecksumError{"masked low",
+			binary.LittleEndian.Uint16(stored), binary.LittleEndian.Uint16(low[:])}
+	}
+	if stored := data[puzOffsetMaskedHigh : puzOffsetMaskedHigh+4]; !bytes.Equal(stored, high[:]) {
+		return nil, &PuzChecksumError{"masked high",
+			binary.LittleEndian.Uint16(stored), binary.LittleEndian.Uint16(high[:])}
+	}
+	return f, nil
+}
+
+// scrambled reports whether the solution has been scrambled with a key.
+func (f *puzFile) scrambled() bool {
+	return f.scrambledTag&puzScrambledTag != 0
+}
+
+func parsePuz(data []byte, id string) (Puzzle, error) {
+	f, err := decodePuz(data)
+	if err != nil {
+		return Puzzle{}, err
+	}
+
+	clueTexts := make([]string, len(f.clues))
+	for i, clue := range f.clues {
+		clueTexts[i] = string(util.Utf8(clue))
+	}
+	grid := string(f.solution)
+	acrossClues, downClues, err := NumberClues(grid, f.width, f.height, clueTexts)
+	if err != nil {
+		return Puzzle{}, err
+	}
+
+	flags, rebus, userRebus, timer, err := f.extensions()
+	if err != nil {
+		return Puzzle{}, err
+	}
+
+	puzzle := Puzzle{
+		ID:                id,
+		Version:           f.version,
+		Width:             f.width,
+		Height:            f.height,
+		NumClues:          f.numClues,
+		Grid:              grid,
+		Scrambled:         f.scrambled(),
+		ScrambledChecksum: f.scrambledChecksum,
+		AcrossClues:       acrossClues,
+		DownClues:         downClues,
+		Title:             string(util.Utf8(f.title)),
+		Creators:          string(util.Utf8(f.author)),
+		Attribution:       string(util.Utf8(f.copyright)),
+		Notes:             string(util.Utf8(f.notes)),
+		Flags:             flags,
+		Rebus:             rebus,
+		UserRebus:         userRebus,
+		Timer:             timer,
+	}
+	return puzzle, nil
+}
+
+// Progress is a solver's fill and markings, saved alongside a puzzle.
+type Progress struct {
+	// Entries by cell index. Rebus cells hold more than one letter.
+	State []string
+	// Flags are merged with the puzzle's own flags.
+	Flags []CellFlags
+	Timer *PuzzleTimer
+}
+
+// encode returns the bytes of a .puz file, with checksums filled in.
+func (f *puzFile) encode() []byte {
+	var b bytes.Buffer
+	b.Write(make([]byte, puzHeaderSize))
+	b.Write(f.solution)
+	b.Write(f.state)
+	for _, s := range [][]byte{f.title, f.author, f.copyright} {
+		b.Write(s)
+		b.WriteByte(0)
+	}
+	for _, clue := range f.clues {
+		b.Write(clue)
+		b.WriteByte(0)
+	}
+	b.Write(f.notes)
+	b.WriteByte(0)
+	for _, s := range f.sections {
+		header := make([]byte, 8)
+		copy(header, s.title)
+		binary.LittleEndian.PutUint16(header[4:], uint16(len(s.data)))
+		binary.LittleEndian.PutUint16(header[6:], puzChecksum(s.data, 0))
+		b.Write(header)
+		b.Write(s.data)
+		b.WriteByte(0)
+	}
+
+	data := b.Bytes()
+	cib, global, low, high := f.checksums()
+	binary.LittleEndian.PutUint16(data[puzOffsetChecksum:], global)
+	copy(data[puzOffsetMagic:], puzMagic)
+	binary.LittleEndian.PutUint16(data[puzOffsetCIBChecksum:], cib)
+	copy(data[puzOffsetMaskedLow:], low[:])
+	copy(data[puzOffsetMaskedHigh:], high[:])
+	copy(data[puzOffsetVersion:], f.version)
+	binary.LittleEndian.PutUint16(data[puzOffsetScrambledChecksum:], f.scrambledChecksum)
+	copy(data[puzOffsetWidth:], f.header())
+	return data
+}
+
+// WritePuz encodes a puzzle as a .puz file. If progress is not nil, the
+// solver's fill, markings and timer are saved with it.
+func WritePuz(p Puzzle, progress *Progress) ([]byte, error) {
+	if p.NoSolution {
+		return nil, ErrNoSolution
+	}
+	n := p.Width * p.Height
+	if p.Width <= 0 || p.Height <= 0 || p.Width > 255 || p.Height > 255 || len(p.Grid) != n {
+		return nil, ErrPuzSize
+	}
+
+	f := &puzFile{
+		version:    "1.3",
+		width:      p.Width,
+		height:     p.Height,
+		puzzleType: 0x0001,
+		solution:   []byte(p.Grid),
+		state:      make([]byte, n),
+		title:      util.Latin1(p.Title),
+		author:     util.Latin1(p.Creators),
+		copyright:  util.Latin1(p.Attribution),
+		notes:      util.Latin1(p.Notes),
+	}
+	if p.Scrambled {
+		f.scrambledTag = puzScrambledTag
+		f.scrambledChecksum = p.ScrambledChecksum
+	}
+
+	// Clues are stored in numbering order, with across before down.
+	clues := append(append([]Clue{}, p.AcrossClues...), p.DownClues...)
+	sort.SliceStable(clues, func(i, j int) bool {
+		if clues[i].Number != clues[j].Number {
+			return clues[i].Number < clues[j].Number
+		}
+		return clues[i].Direction < clues[j].Direction
+	})
+	f.numClues = len(clues)
+	f.clues = make([][]byte, len(clues))
+	for i, clue := range clues {
+		f.clues[i] = util.Latin1(clue.Text)
+	}
+
+	flags := make([]CellFlags, n)
+	copy(flags, p.Flags)
+	userRebus := make([][]byte, n)
+	hasUserRebus := false
+	for i := 0; i < n; i++ {
+		switch {
+		case p.Grid[i] == '.':
+			f.state[i] = '.'
+		case progress != nil && i < len(progress.State) && progress.State[i] != "":
+			entry := progress.State[i]
+			f.state[i] = entry[0]
+			if len(entry) > 1 {
+				userRebus[i] = util.Latin1(entry)
+				hasUserRebus = true
+			}
+		default:
+			f.state[i] = '-'
+		}
+		if progress != nil && i < len(progress.Flags) {
+			flags[i] |= progress.Flags[i]
+		}
+	}
+
+	if len(p.Rebus) > 0 {
+		indices := make([]int, 0, len(p.Rebus))
+		for index := range p.Rebus {
+			indices = append(indices, index)
+		}
+		sort.Ints(indices)
+		keys := make(map[string]int)
+		grid := make([]byte, n)
+		var table bytes.Buffer
+		for _, index := range indices {
+			answer := p.Rebus[index]
+			key, ok := keys[answer]
+			if !ok {
+				key = len(keys)
+				keys[answer] = key
+				fmt.Fprintf(&table, "%2d:%s;", key, util.Latin1(answer))
+			}
+			if index < n {
+				grid[index] = byte(key + 1)
+			}
+		}
+		f.sections = append(f.sections,
+			puzSection{puzSectionRebusGrid, grid},
+			puzSection{puzSectionRebusTable, table.Bytes()})
+	}
+
+	timer := p.Timer
+	if progress != nil && progress.Timer != nil {
+		timer = progress.Timer
+	}
+	if timer != nil {
+		stopped := 0
+		if timer.Stopped {
+			stopped = 1
+		}
+		f.sections = append(f.sections, puzSection{puzSectionTimer,
+			[]byte(fmt.Sprintf("%d,%d", timer.Elapsed, stopped))})
+	}
+
+	markup := make([]byte, n)
+	hasMarkup := false
+	for i, flag := range flags {
+		// Flags with no .puz equivalent are dropped.
+		markup[i] = byte(flag & 0xFF)
+		if markup[i] != 0 {
+			hasMarkup = true
+		}
+	}
+	if hasMarkup {
+		f.sections = append(f.sections, puzSection{puzSectionMarkup, markup})
+	}
+
+	if hasUserRebus {
+		var rusr bytes.Buffer
+		for _, entry := range userRebus {
+			rusr.Write(entry)
+			rusr.WriteByte(0)
+		}
+		f.sections = append(f.sections, puzSection{puzSectionUserRebus, rusr.Bytes()})
+	}
+
+	return f.encode(), nil
+}
@@ -0,0 +1,55 @@
+package format
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// TestPuzChecksums checks that decoding a .puz file verifies each of its
+// checksums, and rejects malformed headers.
+func TestPuzChecksums(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "wsj.puz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// set returns a copy of the file with the byte at offset changed.
+	set := func(offset int, b byte) []byte {
+		changed := append([]byte(nil), data...)
+		changed[offset] = b
+		return changed
+	}
+	tests := []struct {
+		name    string
+		data    []byte
+		section string
+		err     error
+	}{
+		{"valid", data, "", nil},
+		{"preamble", append([]byte("junk"), data...), "", nil},
+		{"global checksum", set(puzOffsetChecksum, data[puzOffsetChecksum]+1), "global", nil},
+		{"puzzle type", set(puzOffsetPuzzleType, data[puzOffsetPuzzleType]+1), "CIB", nil},
+		{"solution", set(puzHeaderSize, data[puzHeaderSize]+1), "global", nil},
+		{"masked low", set(puzOffsetMaskedLow, data[puzOffsetMaskedLow]+1), "masked low", nil},
+		{"masked high", set(puzOffsetMaskedHigh+3, data[puzOffsetMaskedHigh+3]+1), "masked high", nil},
+		{"magic", set(puzOffsetMagic, 'a'), "", ErrPuzMagic},
+		{"version", set(puzOffsetVersion, 'x'), "", ErrPuzVersion},
+		{"truncated header", data[:puzHeaderSize-1], "", ErrPuzTruncated},
+		{"truncated clues", data[:len(data)/2], "", ErrPuzTruncated},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := decodePuz(test.data)
+			var checksumErr *PuzChecksumError
+			switch {
+			case test.section != "":
+				if !errors.As(err, &checksumErr) || checksumErr.Section != test.section {
+					t.Errorf("got %v, want a %v checksum mismatch", err, test.section)
+				}
+			case err != test.err:
+				t.Errorf("got %v, want %v", err, test.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package format
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tmngo/crossword-server/util"
+)
+
+// Titles of the extra sections that may follow the strings of a .puz file.
+const (
+	puzSectionRebusGrid  = "GRBS"
+	puzSectionRebusTable = "RTBL"
+	puzSectionTimer      = "LTIM"
+	puzSectionMarkup     = "GEXT"
+	puzSectionUserRebus  = "RUSR"
+)
+
+// ErrPuzSection is returned when an extra section cannot be parsed.
+var ErrPuzSection = errors.New("puz: malformed extra section")
+
+// puzSection is an extra section of a .puz file.
+type puzSection struct {
+	title string
+	data  []byte
+}
+
+// decodePuzSections reads the extra sections remaining in r, verifying the
+// checksum of each. n is the number of cells in the grid.
+func decodePuzSections(r *puzReader, n int) ([]puzSection, error) {
+	var sections []puzSection
+	for r.offset < len(r.data) {
+		header, err := r.next(8)
+		if err != nil {
+			return nil, err
+		}
+		title := string(header[:4])
+		length := int(binary.LittleEndian.Uint16(header[4:]))
+		stored := binary.LittleEndian.Uint16(header[6:])
+		data, err := r.next(length)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := r.next(1); err != nil {
+			return nil, err
+		}
+		if computed := puzChecksum(data, 0); computed != stored {
+			return nil, &PuzChecksumError{title, stored, computed}
+		}
+		switch title {
+		case puzSectionRebusGrid, puzSectionMarkup:
+			if length != n {
+				return nil, fmt.Errorf("%w: %s has %d bytes for %d cells", ErrPuzSection, title, length, n)
+			}
+		}
+		sections = append(sections, puzSection{title, data})
+	}
+	return sections, nil
+}
+
+// section returns the data of the first section with the given title.
+func (f *puzFile) section(title string) ([]byte, bool) {
+	for _, s := range f.sections {
+		if s.title == title {
+			return s.data, true
+		}
+	}
+	return nil, false
+}
+
+// extensions converts the extra sections into cell flags, the rebus
+// solutions, the solver's rebus entries and the saved timer.
+func (f *puzFile) extensions() ([]CellFlags, map[int]string, map[int]string, *PuzzleTimer, error) {
+	var flags []CellFlags
+	if data, ok := f.section(puzSectionMarkup); ok {
+		flags = make([]CellFlags, len(data))
+		for i, b := range data {
+			flags[i] = CellFlags(b)
+		}
+	}
+
+	var rebus map[int]string
+	if grid, ok := f.section(puzSectionRebusGrid); ok {
+		data, ok := f.section(puzSectionRebusTable)
+		if !ok {
+			return nil, nil, nil, nil, fmt.Errorf("%w: %s without %s", ErrPuzSection, puzSectionRebusGrid, puzSectionRebusTable)
+		}
+		table, err := parseRebusTable(data)
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+		rebus = make(map[int]string)
+		for i, b := range grid {
+			if b == 0 {
+				continue
+			}
+			answer, ok := table[int(b)-1]
+			if !ok {
+				return nil, nil, nil, nil, fmt.Errorf("%w: no %s entry for key %d", ErrPuzSection, puzSectionRebusTable, int(b)-1)
+			}
+			rebus[i] = answer
+		}
+	}
+
+	var userRebus map[int]string
+	if data, ok := f.section(puzSectionUserRebus); ok {
+		r := &puzReader{data: data}
+		userRebus = make(map[int]string)
+		for i := 0; i < f.width*f.height; i++ {
+			entry, err := r.nextString()
+			if err != nil {
+				return nil, nil, nil, nil, fmt.Errorf("%w: %s has fewer entries than cells", ErrPuzSection, puzSectionUserRebus)
+			}
+			if len(entry) > 0 {
+				userRebus[i] = string(util.Utf8(entry))
+			}
+		}
+	}
+
+	var timer *PuzzleTimer
+	if data, ok := f.section(puzSectionTimer); ok {
+		t, err := parsePuzTimer(data)
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+		timer = &t
+	}
+
+	return flags, rebus, userRebus, timer, nil
+}
+
+// parseRebusTable parses an RTBL section such as " 1:HEART; 2:DIAMOND;".
+func parseRebusTable(data []byte) (map[int]string, error) {
+	table := make(map[int]string)
+	for _, entry := range strings.Split(string(util.Utf8(data)), ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%w: bad %s entry %q", ErrPuzSection, puzSectionRebusTable, entry)
+		}
+		key, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad %s key %q", ErrPuzSection, puzSectionRebusTable, parts[0])
+		}
+		table[key] = parts[1]
+	}
+	return table, nil
+}
+
+// parsePuzTimer parses an LTIM section such as "42,0", where the first field
+// is the elapsed time in seconds and the second is 1 if the timer is stopped.
+func parsePuzTimer(data []byte) (PuzzleTimer, error) {
+	parts := strings.Split(string(bytes.TrimRight(data, "\x00")), ",")
+	if len(parts) != 2 {
+		return PuzzleTimer{}, fmt.Errorf("%w: bad %s %q", ErrPuzSection, puzSectionTimer, data)
+	}
+	elapsed, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return PuzzleTimer{}, fmt.Errorf("%w: bad %s %q", ErrPuzSection, puzSectionTimer, data)
+	}
+	return PuzzleTimer{
+		Elapsed: elapsed,
+		Stopped: parts[1] == "1",
+	}, nil
+}
@@ -0,0 +1,154 @@
+package format
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestPuzSections checks that the extra sections of a .puz file are parsed
+// into flags, rebus answers and the timer, and that malformed ones are
+// rejected.
+func TestPuzSections(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "wsj.puz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := decodePuz(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := f.width * f.height
+	// cells returns a section with a byte per cell, set as given by index.
+	cells := func(set map[int]byte) []byte {
+		b := make([]byte, n)
+		for i, v := range set {
+			b[i] = v
+		}
+		return b
+	}
+	userRebus := []byte("HEART\x00")
+	for i := 1; i < n; i++ {
+		userRebus = append(userRebus, 0)
+	}
+
+	tests := []struct {
+		name     string
+		sections []puzSection
+		// corrupt changes the last byte of the last section's data.
+		corrupt   bool
+		flags     map[int]CellFlags
+		rebus     map[int]string
+		userRebus map[int]string
+		timer     *PuzzleTimer
+		err       error
+	}{
+		{
+			name:     "markup",
+			sections: []puzSection{{puzSectionMarkup, cells(map[int]byte{0: 0x80, 2: 0x40})}},
+			flags:    map[int]CellFlags{0: FlagCircled, 2: FlagRevealed},
+		},
+		{
+			name: "rebus",
+			sections: []puzSection{
+				{puzSectionRebusGrid, cells(map[int]byte{0: 1, 5: 11})},
+				{puzSectionRebusTable, []byte(" 0:HEART;10:DIAMOND;")},
+			},
+			rebus: map[int]string{0: "HEART", 5: "DIAMOND"},
+		},
+		{
+			name:      "user rebus",
+			sections:  []puzSection{{puzSectionUserRebus, userRebus}},
+			userRebus: map[int]string{0: "HEART"},
+		},
+		{
+			name:     "timer",
+			sections: []puzSection{{puzSectionTimer, []byte("42,1")}},
+			timer:    &PuzzleTimer{Elapsed: 42, Stopped: true},
+		},
+		{
+			name:     "markup size",
+			sections: []puzSection{{puzSectionMarkup, make([]byte, n-1)}},
+			err:      ErrPuzSection,
+		},
+		{
+			name:     "rebus without table",
+			sections: []puzSection{{puzSectionRebusGrid, cells(map[int]byte{0: 1})}},
+			err:      ErrPuzSection,
+		},
+		{
+			name: "rebus key missing",
+			sections: []puzSection{
+				{puzSectionRebusGrid, cells(map[int]byte{0: 2})},
+				{puzSectionRebusTable, []byte(" 0:HEART;")},
+			},
+			err: ErrPuzSection,
+		},
+		{
+			name: "rebus table entry",
+			sections: []puzSection{
+				{puzSectionRebusGrid, cells(map[int]byte{0: 1})},
+				{puzSectionRebusTable, []byte(" 0 HEART;")},
+			},
+			err: ErrPuzSection,
+		},
+		{
+			name:     "user rebus short",
+			sections: []puzSection{{puzSectionUserRebus, []byte("HEART\x00")}},
+			err:      ErrPuzSection,
+		},
+		{
+			name:     "timer fields",
+			sections: []puzSection{{puzSectionTimer, []byte("42")}},
+			err:      ErrPuzSection,
+		},
+		{
+			name:     "checksum",
+			sections: []puzSection{{puzSectionMarkup, cells(nil)}},
+			corrupt:  true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := *f
+			file.sections = test.sections
+			encoded := file.encode()
+			if test.corrupt {
+				encoded[len(encoded)-2]++
+			}
+			p, err := parsePuz(encoded, "test")
+			if test.corrupt {
+				var checksumErr *PuzChecksumError
+				if !errors.As(err, &checksumErr) || checksumErr.Section != test.sections[0].title {
+					t.Errorf("got %v, want a %s checksum mismatch", err, test.sections[0].title)
+				}
+				return
+			}
+			if !errors.Is(err, test.err) {
+				t.Fatalf("got %v, want %v", err, test.err)
+			}
+			if err != nil {
+				return
+			}
+			if (p.Flags == nil) != (test.flags == nil) {
+				t.Errorf("got flags %v, want %v", p.Flags, test.flags)
+			}
+			for i, flags := range p.Flags {
+				if flags != test.flags[i] {
+					t.Errorf("cell %d has flags %#x, want %#x", i, flags, test.flags[i])
+				}
+			}
+			if !reflect.DeepEqual(p.Rebus, test.rebus) {
+				t.Errorf("got rebus %v, want %v", p.Rebus, test.rebus)
+			}
+			if !reflect.DeepEqual(p.UserRebus, test.userRebus) {
+				t.Errorf("got user rebus %v, want %v", p.UserRebus, test.userRebus)
+			}
+			if !reflect.DeepEqual(p.Timer, test.timer) {
+				t.Errorf("got timer %v, want %v", p.Timer, test.timer)
+			}
+		})
+	}
+}
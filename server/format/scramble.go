@@ -0,0 +1,250 @@
+package format
+
+import (
+	"errors"
+	"strconv"
+)
+
+// Errors returned when scrambling or unscrambling a .puz solution.
+var (
+	ErrPuzKey          = errors.New("puz: key does not unlock the solution")
+	ErrPuzKeyAmbiguous = errors.New("puz: more than one key unlocks the solution")
+	ErrPuzKeyRange     = errors.New("puz: key must be a four-digit number")
+	ErrPuzScramble     = errors.New("puz: solution cannot be scrambled")
+)
+
+// minScrambleLength is the fewest letters Across Lite will scramble.
+const minScrambleLength = 12
+
+// keyDigits splits a key in [1000, 9999] into its four digits.
+func keyDigits(key int) ([4]int, error) {
+	var digits [4]int
+	if key < 1000 || key > 9999 {
+		return digits, ErrPuzKeyRange
+	}
+	for i := 3; i >= 0; i-- {
+		digits[i] = key % 10
+		key /= 10
+	}
+	return digits, nil
+}
+
+// ValidKey reports whether key can scramble a solution.
+func ValidKey(key int) bool {
+	_, err := keyDigits(key)
+	return err == nil
+}
+
+// transpose returns the cells of a width x height grid in column-major
+// order. Transposing the result with the dimensions swapped restores it.
+func transpose(grid string, width, height int) string {
+	out := make([]byte, 0, len(grid))
+	for col := 0; col < width; col++ {
+		for row := 0; row < height; row++ {
+			out = append(out, grid[row*width+col])
+		}
+	}
+	return string(out)
+}
+
+// letters returns the non-block cells of a grid.
+func letters(grid string) []byte {
+	out := make([]byte, 0, len(grid))
+	for i := 0; i < len(grid); i++ {
+		if grid[i] != '.' {
+			out = append(out, grid[i])
+		}
+	}
+	return out
+}
+
+// restoreLetters fills the non-block cells of grid with letters, in order.
+func restoreLetters(grid string, letters []byte) string {
+	out := []byte(grid)
+	j := 0
+	for i := range out {
+		if out[i] != '.' {
+			out[i] = letters[j]
+			j++
+		}
+	}
+	return string(out)
+}
+
+// shiftLetters rotates each letter through the alphabet by the key digit at
+// its position, backwards when sign is negative.
+func shiftLetters(s []byte, digits [4]int, sign int) {
+	for i := range s {
+		shift := sign * digits[i%len(digits)]
+		s[i] = byte('A' + (int(s[i]-'A')+shift+26)%26)
+	}
+}
+
+// shuffle interleaves the second half of s with the first half.
+func shuffle(s []byte) []byte {
+	mid := len(s) / 2
+	out := make([]byte, 0, len(s))
+	for i := 0; i < mid; i++ {
+		out = append(out, s[mid+i], s[i])
+	}
+	if len(s)%2 == 1 {
+		out = append(out, s[len(s)-1])
+	}
+	return out
+}
+
+// unshuffle reverses shuffle.
+func unshuffle(s []byte) []byte {
+	out := make([]byte, 0, len(s))
+	for i := 1; i < len(s); i += 2 {
+		out = append(out, s[i])
+	}
+	for i := 0; i < len(s); i += 2 {
+		out = append(out, s[i])
+	}
+	return out
+}
+
+func scrambleLetters(s []byte, digits [4]int) []byte {
+	for _, k := range digits {
+		shiftLetters(s, digits, 1)
+		s = append(s[k:], s[:k]...)
+		s = shuffle(s)
+	}
+	return s
+}
+
+func unscrambleLetters(s []byte, digits [4]int) []byte {
+	n := len(s)
+	for i := len(digits) - 1; i >= 0; i-- {
+		k := digits[i]
+		s = unshuffle(s)
+		s = append(s[n-k:], s[:n-k]...)
+		shiftLetters(s, digits, -1)
+	}
+	return s
+}
+
+// validScrambleLetters reports whether a solution can be scrambled.
+func validScrambleLetters(s []byte) bool {
+	if len(s) < minScrambleLength {
+		return false
+	}
+	for _, c := range s {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// scrambledChecksum computes the checksum Across Lite stores for an
+// unscrambled solution, used to verify a key.
+func scrambledChecksum(grid string, width, height int) uint16 {
+	return puzChecksum(letters(transpose(grid, width, height)), 0)
+}
+
+// scrambleSolution scrambles a solution grid with a four-digit key.
+func scrambleSolution(grid string, width, height, key int) (string, error) {
+	digits, err := keyDigits(key)
+	if err != nil {
+		return "", err
+	}
+	columns := transpose(grid, width, height)
+	s := letters(columns)
+	if !validScrambleLetters(s) {
+		return "", ErrPuzScramble
+	}
+	columns = restoreLetters(columns, scrambleLetters(s, digits))
+	return transpose(columns, height, width), nil
+}
+
+// unscrambleSolution reverses scrambleSolution. It does not verify the key.
+func unscrambleSolution(grid string, width, height, key int) (string, error) {
+	digits, err := keyDigits(key)
+	if err != nil {
+		return "", err
+	}
+	columns := transpose(grid, width, height)
+	s := letters(columns)
+	if !validScrambleLetters(s) {
+		return "", ErrPuzScramble
+	}
+	columns = restoreLetters(columns, unscrambleLetters(s, digits))
+	return transpose(columns, height, width), nil
+}
+
+// unlocks reports whether key unscrambles the puzzle to a solution matching the
+// scrambled checksum, and returns that solution.
+func unlocks(puzzle *Puzzle, key int) (string, bool, error) {
+	grid, err := unscrambleSolution(puzzle.Grid, puzzle.Width, puzzle.Height, key)
+	if err != nil {
+		return "", false, err
+	}
+	if scrambledChecksum(grid, puzzle.Width, puzzle.Height) != puzzle.ScrambledChecksum {
+		return "", false, nil
+	}
+	// Scrambling the solution again must give back the stored grid.
+	scrambled, err := scrambleSolution(grid, puzzle.Width, puzzle.Height, key)
+	return grid, err == nil && scrambled == puzzle.Grid, err
+}
+
+// Unlock replaces a scrambled solution with the unscrambled one. If key is
+// empty, every four-digit key is tried. The scrambled checksum is only 16
+// bits, so roughly one puzzle in seven has a wrong key that also matches it;
+// when more than one key matches, the puzzle is left scrambled.
+func Unlock(puzzle *Puzzle, key string) error {
+	if !puzzle.Scrambled {
+		return nil
+	}
+	keys := []int{}
+	if key != "" {
+		k, err := strconv.Atoi(key)
+		if err != nil {
+			return ErrPuzKeyRange
+		}
+		keys = append(keys, k)
+	} else {
+		for k := 1000; k <= 9999; k++ {
+			keys = append(keys, k)
+		}
+	}
+	var solution string
+	matches := 0
+	for _, k := range keys {
+		grid, ok, err := unlocks(puzzle, k)
+		if err != nil {
+			return err
+		}
+		if ok {
+			solution = grid
+			matches++
+		}
+	}
+	switch {
+	case matches == 0:
+		return ErrPuzKey
+	case matches > 1:
+		return ErrPuzKeyAmbiguous
+	}
+	puzzle.Grid = solution
+	puzzle.Scrambled = false
+	puzzle.ScrambledChecksum = 0
+	return nil
+}
+
+// Lock scrambles the solution of an unscrambled puzzle with key, for
+// export.
+func Lock(puzzle *Puzzle, key int) error {
+	if puzzle.Scrambled {
+		return nil
+	}
+	grid, err := scrambleSolution(puzzle.Grid, puzzle.Width, puzzle.Height, key)
+	if err != nil {
+		return err
+	}
+	puzzle.ScrambledChecksum = scrambledChecksum(puzzle.Grid, puzzle.Width, puzzle.Height)
+	puzzle.Grid = grid
+	puzzle.Scrambled = true
+	return nil
+}
@@ -0,0 +1,114 @@
+package format
+
+import "testing"
+
+// scrambleVector is a grid scrambled with key 1234 by Across Lite's
+// algorithm: the letters are read column by column, then for each key digit
+// shifted through the alphabet by the key, rotated left by the digit and
+// interleaved.
+var scrambleVector = struct {
+	grid, scrambled string
+	width, height   int
+	key             int
+	checksum        uint16
+}{
+	grid:      "CATSA.HERODESNOW",
+	scrambled: "EDHEL.KMLLIWWPEZ",
+	width:     4,
+	height:    4,
+	key:       1234,
+	checksum:  0x90f2,
+}
+
+func TestScrambleSolution(t *testing.T) {
+	v := scrambleVector
+	scrambled, err := scrambleSolution(v.grid, v.width, v.height, v.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scrambled != v.scrambled {
+		t.Errorf("scrambled grid is %q, want %q", scrambled, v.scrambled)
+	}
+	grid, err := unscrambleSolution(v.scrambled, v.width, v.height, v.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grid != v.grid {
+		t.Errorf("unscrambled grid is %q, want %q", grid, v.grid)
+	}
+	if sum := scrambledChecksum(v.grid, v.width, v.height); sum != v.checksum {
+		t.Errorf("checksum is %#x, want %#x", sum, v.checksum)
+	}
+
+	if _, err := scrambleSolution(v.grid, v.width, v.height, 123); err != ErrPuzKeyRange {
+		t.Errorf("short key: got %v, want %v", err, ErrPuzKeyRange)
+	}
+	if _, err := scrambleSolution("CAT.DOG", 7, 1, v.key); err != ErrPuzScramble {
+		t.Errorf("short grid: got %v, want %v", err, ErrPuzScramble)
+	}
+}
+
+// TestLockPuzzle checks that a locked puzzle written as .puz is read back
+// scrambled, and unlocks with its key or by trying every key.
+func TestLockPuzzle(t *testing.T) {
+	puzzle := readTestPuz(t, "wsj.puz")
+	locked := puzzle
+	if err := Lock(&locked, 7309); err != nil {
+		t.Fatal(err)
+	}
+	data, err := WritePuz(locked, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parsePuz(data, puzzle.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Scrambled || got.Grid == puzzle.Grid {
+		t.Fatal("written puzzle is not scrambled")
+	}
+
+	if err := Unlock(&Puzzle{Grid: got.Grid, Width: got.Width, Height: got.Height,
+		Scrambled: true, ScrambledChecksum: got.ScrambledChecksum}, "1234"); err != ErrPuzKey {
+		t.Errorf("wrong key: got %v, want %v", err, ErrPuzKey)
+	}
+	for _, key := range []string{"7309", ""} {
+		unlocked := got
+		if err := Unlock(&unlocked, key); err != nil {
+			t.Fatalf("key %q: %v", key, err)
+		}
+		if unlocked.Scrambled || unlocked.Grid != puzzle.Grid {
+			t.Errorf("key %q: grid is %q, want %q", key, unlocked.Grid, puzzle.Grid)
+		}
+	}
+}
+
+// TestUnlockAmbiguous checks that a solution more than one key unlocks is
+// left scrambled. wsj.puz locked with 2295 also matches the checksum when
+// unscrambled with 1649, which is tried first.
+func TestUnlockAmbiguous(t *testing.T) {
+	puzzle := readTestPuz(t, "wsj.puz")
+	locked := puzzle
+	if err := Lock(&locked, 2295); err != nil {
+		t.Fatal(err)
+	}
+	if grid, ok, _ := unlocks(&locked, 1649); !ok || grid == puzzle.Grid {
+		t.Fatal("key 1649 no longer matches the checksum with a wrong solution")
+	}
+
+	guessed := locked
+	if err := Unlock(&guessed, ""); err != ErrPuzKeyAmbiguous {
+		t.Errorf("got %v, want %v", err, ErrPuzKeyAmbiguous)
+	}
+	if !guessed.Scrambled || guessed.Grid != locked.Grid {
+		t.Error("ambiguous solution was changed")
+	}
+
+	unlocked := locked
+	if err := Unlock(&unlocked, "2295"); err != nil {
+		t.Fatal(err)
+	}
+	if unlocked.Grid != puzzle.Grid {
+		t.Errorf("grid is %q, want %q", unlocked.Grid, puzzle.Grid)
+	}
+}
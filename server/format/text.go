@@ -0,0 +1,338 @@
+package format
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrTextFormat is returned when an Across Lite text file cannot be parsed.
+var ErrTextFormat = errors.New("txt: malformed Across Lite text file")
+
+const (
+	textHeader   = "<ACROSS PUZZLE>"
+	textHeaderV2 = "<ACROSS PUZZLE V2>"
+)
+
+// rebusKeys are the grid characters used for rebus cells in the text and xd
+// formats, in the order they are assigned when writing. They exclude letters
+// and digits, which may be answers, and the characters those formats use for
+// blocks, separators and section headers.
+const rebusKeys = "!@$%^&*+?~|/()[]{}"
+
+// rebusKeyMap assigns a grid character to each distinct rebus answer. Keys
+// that appear as entries elsewhere in the grid are skipped.
+func rebusKeyMap(p Puzzle) (map[string]byte, error) {
+	answers := make([]string, 0, len(p.Rebus))
+	seen := make(map[string]bool)
+	for _, answer := range p.Rebus {
+		if !seen[answer] {
+			seen[answer] = true
+			answers = append(answers, answer)
+		}
+	}
+	sort.Strings(answers)
+	keys := make(map[string]byte)
+	k := 0
+	for _, answer := range answers {
+		for k < len(rebusKeys) && strings.IndexByte(p.Grid, rebusKeys[k]) >= 0 {
+			k++
+		}
+		if k == len(rebusKeys) {
+			return nil, fmt.Errorf("Too many distinct rebus answers (%d).", len(answers))
+		}
+		keys[answer] = rebusKeys[k]
+		k++
+	}
+	return keys, nil
+}
+
+// wordAnswer returns the solution of a clue's word, using the rebus table.
+func wordAnswer(p Puzzle, clue Clue) string {
+	var b strings.Builder
+	row, col := clue.Row, clue.Column
+	for i := 0; i < clue.Length; i++ {
+		index := row*p.Width + col
+		if answer, ok := p.Rebus[index]; ok {
+			b.WriteString(answer)
+		} else {
+			b.WriteByte(p.Grid[index])
+		}
+		if clue.Direction == Across {
+			col++
+		} else {
+			row++
+		}
+	}
+	return b.String()
+}
+
+// decodeGridRows converts the rows of a text grid into a solution grid,
+// where block is the block character, lowercase letters are circled if
+// lowercaseCircles is set, and rebus maps key characters to answers.
+func decodeGridRows(rows []string, width int, block byte, lowercaseCircles bool, rebus map[byte]string) (Puzzle, error) {
+	height := len(rows)
+	n := width * height
+	p := Puzzle{Width: width, Height: height}
+	grid := make([]byte, n)
+	flags := make([]CellFlags, n)
+	hasFlags := false
+	for row, line := range rows {
+		if len(line) != width {
+			return Puzzle{}, fmt.Errorf("%w: row %d has %d cells, want %d", ErrTextFormat, row+1, len(line), width)
+		}
+		for col := 0; col < width; col++ {
+			index := row*width + col
+			c := line[col]
+			switch {
+			case c == block:
+				grid[index] = '.'
+			case rebus[c] != "":
+				answer := rebus[c]
+				grid[index] = answer[0]
+				if len(answer) > 1 {
+					if p.Rebus == nil {
+						p.Rebus = make(map[int]string)
+					}
+					p.Rebus[index] = answer
+				}
+			case c >= 'a' && c <= 'z':
+				grid[index] = c - 32
+				if lowercaseCircles {
+					flags[index] |= FlagCircled
+					hasFlags = true
+				}
+			default:
+				grid[index] = c
+			}
+		}
+	}
+	p.Grid = string(grid)
+	if hasFlags {
+		p.Flags = flags
+	}
+	return p, nil
+}
+
+// encodeGridRows converts a solution grid into text rows, using block for
+// blocks, key characters for rebus cells and lowercase for circled cells.
+func encodeGridRows(p Puzzle, block byte, keys map[string]byte) []string {
+	rows := make([]string, p.Height)
+	for row := 0; row < p.Height; row++ {
+		line := make([]byte, p.Width)
+		for col := 0; col < p.Width; col++ {
+			index := row*p.Width + col
+			c := p.Grid[index]
+			switch {
+			case c == '.':
+				c = block
+			case p.Rebus[index] != "":
+				c = keys[p.Rebus[index]]
+			case index < len(p.Flags) && p.Flags[index]&FlagCircled != 0 && c >= 'A' && c <= 'Z':
+				c += 32
+			}
+			line[col] = c
+		}
+		rows[row] = string(line)
+	}
+	return rows
+}
+
+// assignClueTexts sets the text of each numbered entry from a list of texts
+// in numbering order.
+func assignClueTexts(clues []Clue, texts []string) error {
+	if len(clues) != len(texts) {
+		return ErrPuzClueCount
+	}
+	for i := range clues {
+		clues[i].Text = texts[i]
+	}
+	return nil
+}
+
+// textClues returns the clue texts of a section with n clues. Blank lines are
+// skipped, unless keeping them gives one line per clue, as it does when a
+// clue's text is empty.
+func textClues(lines, all []string, n int) []string {
+	if len(lines) == n {
+		return lines
+	}
+	for len(all) > n && all[0] == "" {
+		all = all[1:]
+	}
+	for len(all) > n && all[len(all)-1] == "" {
+		all = all[:len(all)-1]
+	}
+	if len(all) == n {
+		return all
+	}
+	return lines
+}
+
+func parseText(data []byte, id string) (Puzzle, error) {
+	sections := make(map[string][]string)
+	// all holds every line of each section, including blank ones.
+	all := make(map[string][]string)
+	var order []string
+	var current string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "<") && strings.HasSuffix(trimmed, ">") {
+			current = trimmed
+			order = append(order, current)
+			sections[current] = []string{}
+			continue
+		}
+		if current == "" {
+			if trimmed == "" {
+				continue
+			}
+			return Puzzle{}, fmt.Errorf("%w: text before %s", ErrTextFormat, textHeader)
+		}
+		all[current] = append(all[current], trimmed)
+		if trimmed == "" && current != "<NOTEPAD>" {
+			continue
+		}
+		sections[current] = append(sections[current], trimmed)
+	}
+	if err := scanner.Err(); err != nil {
+		return Puzzle{}, err
+	}
+	if len(order) == 0 || (order[0] != textHeader && order[0] != textHeaderV2) {
+		return Puzzle{}, fmt.Errorf("%w: missing %s", ErrTextFormat, textHeader)
+	}
+
+	size := sections["<SIZE>"]
+	if len(size) == 0 {
+		return Puzzle{}, fmt.Errorf("%w: missing <SIZE>", ErrTextFormat)
+	}
+	dims := strings.SplitN(strings.ToLower(size[0]), "x", 2)
+	if len(dims) != 2 {
+		return Puzzle{}, fmt.Errorf("%w: bad size %q", ErrTextFormat, size[0])
+	}
+	width, err := strconv.Atoi(strings.TrimSpace(dims[0]))
+	if err != nil {
+		return Puzzle{}, fmt.Errorf("%w: bad size %q", ErrTextFormat, size[0])
+	}
+	height, err := strconv.Atoi(strings.TrimSpace(dims[1]))
+	if err != nil {
+		return Puzzle{}, fmt.Errorf("%w: bad size %q", ErrTextFormat, size[0])
+	}
+	rows := sections["<GRID>"]
+	if width <= 0 || height <= 0 || len(rows) != height {
+		return Puzzle{}, fmt.Errorf("%w: grid does not match size %q", ErrTextFormat, size[0])
+	}
+
+	// Each rebus line is "key:ANSWER:letter". A "MARK;" line means lowercase
+	// letters in the grid are circled.
+	rebus := make(map[byte]string)
+	mark := false
+	for _, line := range sections["<REBUS>"] {
+		if strings.EqualFold(line, "MARK;") {
+			mark = true
+			continue
+		}
+		parts := strings.Split(line, ":")
+		if len(parts) < 2 || len(parts[0]) != 1 {
+			return Puzzle{}, fmt.Errorf("%w: bad rebus %q", ErrTextFormat, line)
+		}
+		rebus[parts[0][0]] = strings.ToUpper(parts[1])
+	}
+
+	p, err := decodeGridRows(rows, width, '.', mark, rebus)
+	if err != nil {
+		return Puzzle{}, err
+	}
+	across, down, err := NumberClues(p.Grid, width, height, nil)
+	if err != nil {
+		return Puzzle{}, err
+	}
+	if err := assignClueTexts(across, textClues(sections["<ACROSS>"], all["<ACROSS>"], len(across))); err != nil {
+		return Puzzle{}, fmt.Errorf("%w: across clues do not match grid", ErrTextFormat)
+	}
+	if err := assignClueTexts(down, textClues(sections["<DOWN>"], all["<DOWN>"], len(down))); err != nil {
+		return Puzzle{}, fmt.Errorf("%w: down clues do not match grid", ErrTextFormat)
+	}
+
+	p.ID = id
+	p.NumClues = len(across) + len(down)
+	p.AcrossClues = across
+	p.DownClues = down
+	p.Title = strings.Join(sections["<TITLE>"], " ")
+	p.Creators = strings.Join(sections["<AUTHOR>"], " ")
+	p.Attribution = strings.Join(sections["<COPYRIGHT>"], " ")
+	p.Notes = strings.TrimSpace(strings.Join(sections["<NOTEPAD>"], "\n"))
+	return p, nil
+}
+
+func writeText(p Puzzle) ([]byte, error) {
+	if p.NoSolution {
+		return nil, ErrNoSolution
+	}
+	if len(p.Grid) != p.Width*p.Height {
+		return nil, ErrPuzSize
+	}
+	keys, err := rebusKeyMap(p)
+	if err != nil {
+		return nil, err
+	}
+	circled := false
+	for _, flag := range p.Flags {
+		if flag&FlagCircled != 0 {
+			circled = true
+		}
+	}
+
+	var b bytes.Buffer
+	section := func(name string, lines ...string) {
+		fmt.Fprintf(&b, "<%s>\n", name)
+		for _, line := range lines {
+			fmt.Fprintf(&b, "\t%s\n", line)
+		}
+	}
+	if len(keys) > 0 || circled {
+		b.WriteString(textHeaderV2 + "\n")
+	} else {
+		b.WriteString(textHeader + "\n")
+	}
+	section("TITLE", p.Title)
+	section("AUTHOR", p.Creators)
+	section("COPYRIGHT", p.Attribution)
+	section("SIZE", fmt.Sprintf("%dx%d", p.Width, p.Height))
+	section("GRID", encodeGridRows(p, '.', keys)...)
+	if len(keys) > 0 || circled {
+		var lines []string
+		if circled {
+			lines = append(lines, "MARK;")
+		}
+		answers := make([]string, 0, len(keys))
+		for answer := range keys {
+			answers = append(answers, answer)
+		}
+		sort.Strings(answers)
+		for _, answer := range answers {
+			lines = append(lines, fmt.Sprintf("%c:%s:%c", keys[answer], answer, answer[0]))
+		}
+		section("REBUS", lines...)
+	}
+	across := make([]string, len(p.AcrossClues))
+	for i, clue := range p.AcrossClues {
+		across[i] = clue.Text
+	}
+	section("ACROSS", across...)
+	down := make([]string, len(p.DownClues))
+	for i, clue := range p.DownClues {
+		down[i] = clue.Text
+	}
+	section("DOWN", down...)
+	if p.Notes != "" {
+		section("NOTEPAD", strings.Split(p.Notes, "\n")...)
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,217 @@
+package format
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrXdFormat is returned when an xd file cannot be parsed.
+var ErrXdFormat = errors.New("xd: malformed xd file")
+
+// parseXdClue parses a clue line such as "A1. Clue text ~ ANSWER".
+func parseXdClue(line string) (Direction, int, string, bool) {
+	if len(line) < 3 || (line[0] != 'A' && line[0] != 'D') {
+		return Across, 0, "", false
+	}
+	dot := strings.Index(line, ".")
+	if dot < 2 {
+		return Across, 0, "", false
+	}
+	number, err := strconv.Atoi(line[1:dot])
+	if err != nil {
+		return Across, 0, "", false
+	}
+	dir := Across
+	if line[0] == 'D' {
+		dir = Down
+	}
+	text := line[dot+1:]
+	if i := strings.LastIndex(text, " ~ "); i != -1 {
+		text = text[:i]
+	}
+	return dir, number, strings.TrimSpace(text), true
+}
+
+// parseXd reads a puzzle in the xd format used by the open crossword corpus.
+// See https://github.com/century-arcade/xd.
+func parseXd(data []byte, id string) (Puzzle, error) {
+	headers := make(map[string]string)
+	var rows []string
+	var clueLines []string
+	var notes []string
+
+	// The file is a run of headers, a grid, the clues and optional notes,
+	// each separated by blank lines.
+	const (
+		stateHeaders = iota
+		stateGrid
+		stateClues
+		stateNotes
+	)
+	state := stateHeaders
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r \t")
+		switch state {
+		case stateHeaders:
+			if line == "" {
+				if len(headers) > 0 {
+					state = stateGrid
+				}
+				continue
+			}
+			parts := strings.SplitN(line, ":", 2)
+			if len(parts) != 2 {
+				// Files without headers start with the grid.
+				state = stateGrid
+				rows = append(rows, strings.TrimSpace(line))
+				continue
+			}
+			headers[strings.ToLower(strings.TrimSpace(parts[0]))] = strings.TrimSpace(parts[1])
+		case stateGrid:
+			if line == "" {
+				if len(rows) > 0 {
+					state = stateClues
+				}
+				continue
+			}
+			rows = append(rows, strings.TrimSpace(line))
+		case stateClues:
+			if line == "" {
+				continue
+			}
+			if _, _, _, ok := parseXdClue(line); ok {
+				clueLines = append(clueLines, line)
+				continue
+			}
+			state = stateNotes
+			notes = append(notes, line)
+		case stateNotes:
+			notes = append(notes, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Puzzle{}, err
+	}
+	if len(rows) == 0 {
+		return Puzzle{}, fmt.Errorf("%w: missing grid", ErrXdFormat)
+	}
+
+	// Rebus cells are listed as "Rebus: 1=HEART 2=DIAMOND".
+	rebus := make(map[byte]string)
+	for _, entry := range strings.Fields(headers["rebus"]) {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || len(parts[0]) != 1 {
+			return Puzzle{}, fmt.Errorf("%w: bad rebus %q", ErrXdFormat, entry)
+		}
+		rebus[parts[0][0]] = strings.ToUpper(parts[1])
+	}
+	// Lowercase letters are special cells, circled unless marked shaded.
+	special := strings.ToLower(headers["special"])
+
+	width := len(rows[0])
+	for _, block := range []byte{'#', '_'} {
+		for i := range rows {
+			rows[i] = strings.Replace(rows[i], string(block), ".", -1)
+		}
+	}
+	p, err := decodeGridRows(rows, width, '.', true, rebus)
+	if err != nil {
+		return Puzzle{}, fmt.Errorf("%w: %v", ErrXdFormat, err)
+	}
+	if special == "shaded" {
+		for i, flag := range p.Flags {
+			if flag&FlagCircled != 0 {
+				p.Flags[i] = flag&^FlagCircled | FlagShaded
+			}
+		}
+	}
+
+	across, down, err := NumberClues(p.Grid, p.Width, p.Height, nil)
+	if err != nil {
+		return Puzzle{}, err
+	}
+	entries := map[Direction]map[int]*Clue{Across: {}, Down: {}}
+	for i := range across {
+		entries[Across][across[i].Number] = &across[i]
+	}
+	for i := range down {
+		entries[Down][down[i].Number] = &down[i]
+	}
+	for _, line := range clueLines {
+		dir, number, text, _ := parseXdClue(line)
+		clue, ok := entries[dir][number]
+		if !ok {
+			return Puzzle{}, fmt.Errorf("%w: no entry for clue %q", ErrXdFormat, line)
+		}
+		clue.Text = text
+	}
+
+	p.ID = id
+	p.NumClues = len(across) + len(down)
+	p.AcrossClues = across
+	p.DownClues = down
+	p.Title = headers["title"]
+	p.Creators = headers["author"]
+	p.Attribution = headers["copyright"]
+	p.Notes = headers["notes"]
+	if extra := strings.TrimSpace(strings.Join(notes, "\n")); extra != "" {
+		p.Notes = strings.TrimSpace(p.Notes + "\n" + extra)
+	}
+	return p, nil
+}
+
+func writeXd(p Puzzle) ([]byte, error) {
+	if p.NoSolution {
+		return nil, ErrNoSolution
+	}
+	if len(p.Grid) != p.Width*p.Height {
+		return nil, ErrPuzSize
+	}
+	keys, err := rebusKeyMap(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	header := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(&b, "%s: %s\n", name, strings.Replace(value, "\n", " ", -1))
+		}
+	}
+	header("Title", p.Title)
+	header("Author", p.Creators)
+	header("Copyright", p.Attribution)
+	if len(keys) > 0 {
+		answers := make([]string, 0, len(keys))
+		for answer := range keys {
+			answers = append(answers, answer)
+		}
+		sort.Strings(answers)
+		entries := make([]string, len(answers))
+		for i, answer := range answers {
+			entries[i] = fmt.Sprintf("%c=%s", keys[answer], answer)
+		}
+		header("Rebus", strings.Join(entries, " "))
+	}
+	header("Notes", p.Notes)
+
+	b.WriteString("\n\n")
+	for _, row := range encodeGridRows(p, '#', keys) {
+		b.WriteString(row + "\n")
+	}
+	b.WriteString("\n\n")
+	for _, clue := range p.AcrossClues {
+		fmt.Fprintf(&b, "A%d. %s ~ %s\n", clue.Number, clue.Text, wordAnswer(p, clue))
+	}
+	b.WriteString("\n")
+	for _, clue := range p.DownClues {
+		fmt.Fprintf(&b, "D%d. %s ~ %s\n", clue.Number, clue.Text, wordAnswer(p, clue))
+	}
+	return b.Bytes(), nil
+}
@@ -5,14 +5,23 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/tmngo/crossword-server/ws"
 )
 
 var addr = flag.String("addr", "localhost:8080", "http service address")
+var cacheDir = flag.String("cache", "cache", "directory for cached puzzles, or empty to cache in memory")
+var cacheSize = flag.Int64("cache-size", 256, "maximum size of the puzzle cache in MiB, or 0 for no limit")
+var roomDir = flag.String("rooms", "rooms", "directory for saved rooms, or empty to keep rooms in memory")
+var puzzleDir = flag.String("puzzles", "", "directory of puzzle files to serve as the \"local\" source")
 
 func serveHome(w http.ResponseWriter, r *http.Request) {
 	log.Println(r.URL, r.URL)
@@ -39,9 +48,29 @@ func serveFavicon(w http.ResponseWriter, r *http.Request) {
 
 func main() {
 	flag.Parse()
+	if *roomDir != "" {
+		store, err := ws.NewRoomStore(*roomDir)
+		if err != nil {
+			log.Fatal("NewRoomStore: ", err)
+		}
+		ws.GlobalRoomStore = store
+	}
 	ws.GlobalHub = ws.NewHub()
 	go ws.GlobalHub.Run()
-	ws.GlobalPuzzleCache = make(map[string]ws.Puzzle)
+	cache, err := ws.NewPuzzleCache(*cacheDir, *cacheSize<<20)
+	if err != nil {
+		log.Fatal("NewPuzzleCache: ", err)
+	}
+	ws.GlobalPuzzleCache = cache
+	ws.GlobalSources = ws.NewSourceRegistry(ws.WallStreetJournal)
+	if *puzzleDir != "" {
+		local, err := ws.NewDirSource("local", "Local puzzles", *puzzleDir)
+		if err != nil {
+			log.Fatal("NewDirSource: ", err)
+		}
+		go local.Watch(10*time.Second, nil)
+		ws.GlobalSources.Register(local)
+	}
 
 	// Matches all paths not matched by other patterns.
 	http.HandleFunc("/favicon.png", serveFavicon)
@@ -49,10 +78,37 @@ func main() {
 	http.HandleFunc("/ws/", func(w http.ResponseWriter, r *http.Request) {
 		ws.ServeWs(ws.GlobalHub, w, r)
 	})
+	http.HandleFunc("/puz/", func(w http.ResponseWriter, r *http.Request) {
+		ws.ServePuz(ws.GlobalHub, w, r)
+	})
+	http.HandleFunc("/summary/", func(w http.ResponseWriter, r *http.Request) {
+		ws.ServeSummary(ws.GlobalHub, w, r)
+	})
+
+	server := &http.Server{Addr: *addr}
+	stopped := make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		log.Print("Shutting down.")
+		// Stop taking connections, then save every room before exiting.
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Print("Shutdown: ", err)
+		}
+		ws.GlobalHub.Shutdown()
+		if err := cache.Flush(); err != nil {
+			log.Print("Flush: ", err)
+		}
+		close(stopped)
+	}()
 
 	log.Printf("Listening on %s.", *addr)
-	err := http.ListenAndServe(*addr, nil)
-	if err != nil {
+	err = server.ListenAndServe()
+	if err != http.ErrServerClosed {
 		log.Fatal("ListenAndServe: ", err)
 	}
+	<-stopped
 }
@@ -53,3 +53,16 @@ func DecodeUTF16(b []byte) (string, error) {
 
 	return ret.String(), nil
 }
+
+// Latin1 encodes a string as ISO-8859-1, replacing characters outside its
+// range with '?'.
+func Latin1(s string) []byte {
+	buf := make([]byte, 0, len(s))
+	for _, r := range s {
+		if r > 0xFF {
+			r = '?'
+		}
+		buf = append(buf, byte(r))
+	}
+	return buf
+}
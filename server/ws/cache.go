@@ -0,0 +1,303 @@
+package ws
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tmngo/crossword-server/format"
+)
+
+const cacheIndexName = "index.json"
+
+// cacheIndexInterval is how often reads save the cache's LRU order.
+const cacheIndexInterval = time.Minute
+
+// ErrCacheIntegrity is returned when a cached file does not match its hash.
+var ErrCacheIntegrity = errors.New("Cached file does not match its hash")
+
+// cacheEntry describes a cached puzzle. The raw source file and the parsed
+// puzzle are stored on disk under the hash of the raw file. Only LastUsed and
+// puzzle change once an entry is created.
+type cacheEntry struct {
+	ID       string    `json:"id"`
+	Hash     string    `json:"hash"`
+	Format   string    `json:"format"`
+	Size     int64     `json:"size"`
+	LastUsed time.Time `json:"lastUsed"`
+
+	puzzle *Puzzle
+}
+
+// PuzzleCache holds parsed puzzles by ID, backed by a directory so they
+// survive restarts. When the cached files exceed the size limit, the least
+// recently used puzzles are evicted. It is safe for concurrent use; files are
+// read and written without holding mu.
+type PuzzleCache struct {
+	mu sync.Mutex
+	// indexMu serializes writes of the index, and is taken before mu.
+	indexMu sync.Mutex
+	// indexSaved is when the index was last written.
+	indexSaved time.Time
+	// dir is empty for a cache that is only kept in memory.
+	dir      string
+	maxBytes int64
+	size     int64
+	entries  map[string]*list.Element
+	// lru orders entries from most to least recently used.
+	lru *list.List
+}
+
+var GlobalPuzzleCache *PuzzleCache
+
+// NewPuzzleCache opens the cache stored in dir, creating it if needed. If
+// dir is empty, puzzles are only cached in memory. A maxBytes of zero or less
+// means no limit.
+func NewPuzzleCache(dir string, maxBytes int64) (*PuzzleCache, error) {
+	c := &PuzzleCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+	if dir == "" {
+		return c, nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, cacheIndexName))
+	if os.IsNotExist(err) {
+		return c, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var entries []*cacheEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		log.Printf("Ignoring corrupt cache index: %v", err)
+		return c, nil
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastUsed.After(entries[j].LastUsed)
+	})
+	for _, entry := range entries {
+		c.entries[entry.ID] = c.lru.PushBack(entry)
+		c.size += entry.Size
+	}
+	c.evict()
+	return c, nil
+}
+
+func (c *PuzzleCache) path(hash, ext string) string {
+	return filepath.Join(c.dir, hash+ext)
+}
+
+// Get returns the puzzle with id. Puzzles read from disk are verified
+// against their hash and the format's own checksums first. The order of use
+// is saved with the index at most every cacheIndexInterval.
+func (c *PuzzleCache) Get(id string) (Puzzle, bool) {
+	c.mu.Lock()
+	element, ok := c.entries[id]
+	if !ok {
+		c.mu.Unlock()
+		return Puzzle{}, false
+	}
+	entry := element.Value.(*cacheEntry)
+	entry.LastUsed = time.Now()
+	c.lru.MoveToFront(element)
+	cached := entry.puzzle
+	save := c.dir != "" && time.Since(c.indexSaved) >= cacheIndexInterval
+	c.mu.Unlock()
+	if save {
+		if err := c.writeIndex(); err != nil {
+			log.Printf("Error saving cache index: %v", err)
+		}
+	}
+	if cached != nil {
+		return *cached, true
+	}
+
+	puzzle, err := c.load(entry)
+	c.mu.Lock()
+	// The entry may have been replaced or dropped while it was loading.
+	current := c.entries[id] == element
+	if current && err == nil {
+		entry.puzzle = &puzzle
+	} else if current {
+		log.Printf("Dropping cached puzzle %v: %v", id, err)
+		c.remove(element)
+	}
+	c.mu.Unlock()
+	if err != nil {
+		if current {
+			c.writeIndex()
+		}
+		return Puzzle{}, false
+	}
+	return puzzle, true
+}
+
+// load reads and verifies a cached puzzle from disk.
+func (c *PuzzleCache) load(entry *cacheEntry) (Puzzle, error) {
+	raw, err := ioutil.ReadFile(c.path(entry.Hash, ".raw"))
+	if err != nil {
+		return Puzzle{}, err
+	}
+	sum := sha256.Sum256(raw)
+	if hex.EncodeToString(sum[:]) != entry.Hash {
+		return Puzzle{}, ErrCacheIntegrity
+	}
+	// Parsing verifies the checksums stored in the file.
+	if _, err := format.Parse(raw, entry.ID, entry.Format); err != nil {
+		return Puzzle{}, err
+	}
+	data, err := ioutil.ReadFile(c.path(entry.Hash, ".json"))
+	if err != nil {
+		return Puzzle{}, err
+	}
+	var puzzle Puzzle
+	if err := json.Unmarshal(data, &puzzle); err != nil {
+		return Puzzle{}, err
+	}
+	puzzle.ID = entry.ID
+	return puzzle, nil
+}
+
+// Put caches a puzzle along with the raw file it was parsed from.
+func (c *PuzzleCache) Put(id, fileFormat string, raw []byte, puzzle Puzzle) error {
+	sum := sha256.Sum256(raw)
+	entry := &cacheEntry{
+		ID:       id,
+		Hash:     hex.EncodeToString(sum[:]),
+		Format:   fileFormat,
+		Size:     int64(len(raw)),
+		LastUsed: time.Now(),
+		puzzle:   &puzzle,
+	}
+
+	if c.dir != "" {
+		data, err := json.Marshal(puzzle)
+		if err != nil {
+			return err
+		}
+		// Files are named by their contents, so writing them can't
+		// disturb other entries.
+		if err := writeFileAtomic(c.path(entry.Hash, ".raw"), raw); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(c.path(entry.Hash, ".json"), data); err != nil {
+			return err
+		}
+		entry.Size += int64(len(data))
+	}
+
+	c.mu.Lock()
+	// The new entry goes in first, so that replacing an entry with the same
+	// contents keeps their files.
+	element := c.lru.PushFront(entry)
+	if old, ok := c.entries[id]; ok {
+		c.remove(old)
+	}
+	c.entries[id] = element
+	c.size += entry.Size
+	c.evict()
+	c.mu.Unlock()
+	return c.writeIndex()
+}
+
+// Delete removes the puzzle with id.
+func (c *PuzzleCache) Delete(id string) {
+	c.mu.Lock()
+	element, ok := c.entries[id]
+	if ok {
+		c.remove(element)
+	}
+	c.mu.Unlock()
+	if ok {
+		c.writeIndex()
+	}
+}
+
+// Flush saves the index, including the order puzzles were last used in.
+func (c *PuzzleCache) Flush() error {
+	return c.writeIndex()
+}
+
+// evict removes least recently used entries until the cache fits its limit.
+func (c *PuzzleCache) evict() {
+	for c.maxBytes > 0 && c.size > c.maxBytes && c.lru.Len() > 1 {
+		element := c.lru.Back()
+		log.Printf("Evicting cached puzzle %v.", element.Value.(*cacheEntry).ID)
+		c.remove(element)
+	}
+}
+
+// remove drops an entry, deleting its files unless another entry has the
+// same contents.
+func (c *PuzzleCache) remove(element *list.Element) {
+	entry := element.Value.(*cacheEntry)
+	c.lru.Remove(element)
+	delete(c.entries, entry.ID)
+	c.size -= entry.Size
+	if c.dir == "" {
+		return
+	}
+	for other := c.lru.Front(); other != nil; other = other.Next() {
+		if other.Value.(*cacheEntry).Hash == entry.Hash {
+			return
+		}
+	}
+	os.Remove(c.path(entry.Hash, ".raw"))
+	os.Remove(c.path(entry.Hash, ".json"))
+}
+
+// writeIndex saves the entries, most recently used first. Each write saves
+// the entries as of when it began, and writes are serialized, so the last
+// one written is the newest.
+func (c *PuzzleCache) writeIndex() error {
+	if c.dir == "" {
+		return nil
+	}
+	c.indexMu.Lock()
+	defer c.indexMu.Unlock()
+	c.mu.Lock()
+	entries := make([]cacheEntry, 0, c.lru.Len())
+	for element := c.lru.Front(); element != nil; element = element.Next() {
+		entries = append(entries, *element.Value.(*cacheEntry))
+	}
+	c.indexSaved = time.Now()
+	c.mu.Unlock()
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(c.dir, cacheIndexName), data)
+}
+
+// writeFileAtomic writes data to a temporary file and renames it into place,
+// so readers never see a partial file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,134 @@
+package ws
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/tmngo/crossword-server/format"
+)
+
+// cacheFiles are the test puzzles put in caches, by ID.
+var cacheFiles = map[string]string{
+	"a": "../format/testdata/wsj.puz",
+	"b": "../format/testdata/wsj210731.puz",
+	"c": "../format/testdata/ucs190331.puz",
+}
+
+// putCached reads a test puzzle and puts it in a cache.
+func putCached(t *testing.T, c *PuzzleCache, id string) {
+	t.Helper()
+	raw, err := ioutil.ReadFile(cacheFiles[id])
+	if err != nil {
+		t.Fatal(err)
+	}
+	puzzle, err := format.Parse(raw, id, format.Puz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Put(id, format.Puz, raw, puzzle); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// cachedIDs returns the IDs in a cache, most recently used first.
+func cachedIDs(c *PuzzleCache) string {
+	ids := ""
+	for element := c.lru.Front(); element != nil; element = element.Next() {
+		ids += element.Value.(*cacheEntry).ID
+	}
+	return ids
+}
+
+// TestCacheEviction checks that the least recently used puzzle is evicted
+// once the cache is over its limit, along with its files.
+func TestCacheEviction(t *testing.T) {
+	c, err := NewPuzzleCache(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		putCached(t, c, id)
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("a is missing")
+	}
+	stale := c.entries["b"].Value.(*cacheEntry)
+	c.maxBytes = c.size - 1
+	putCached(t, c, "c")
+	if got := cachedIDs(c); got != "ca" {
+		t.Fatalf("cache holds %q, want ca", got)
+	}
+	if _, ok := c.Get("b"); ok {
+		t.Error("evicted puzzle was served")
+	}
+	if _, err := os.Stat(c.path(stale.Hash, ".raw")); !os.IsNotExist(err) {
+		t.Errorf("evicted file remains: %v", err)
+	}
+	// Replacing a puzzle with the same file keeps the file.
+	if _, err := os.Stat(c.path(c.entries["c"].Value.(*cacheEntry).Hash, ".raw")); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestCacheIntegrity checks that a cached file that no longer matches its
+// hash is dropped rather than served.
+func TestCacheIntegrity(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewPuzzleCache(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putCached(t, c, "a")
+	hash := c.entries["a"].Value.(*cacheEntry).Hash
+	if err := ioutil.WriteFile(c.path(hash, ".raw"), []byte("corrupt"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewPuzzleCache(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := reopened.Get("a"); ok {
+		t.Fatal("corrupt puzzle was served")
+	}
+	reopened, err = NewPuzzleCache(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := reopened.entries["a"]; ok {
+		t.Error("corrupt puzzle is still in the index")
+	}
+}
+
+// TestCacheReload checks that a reopened cache serves the puzzles saved in
+// it, and evicts by the order they were used in before the restart.
+func TestCacheReload(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewPuzzleCache(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putCached(t, c, "a")
+	putCached(t, c, "b")
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("a is missing")
+	}
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	size := c.lru.Front().Value.(*cacheEntry).Size
+
+	reopened, err := NewPuzzleCache(dir, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cachedIDs(reopened); got != "a" {
+		t.Fatalf("reopened cache holds %q, want a", got)
+	}
+	want, _ := c.Get("a")
+	got, ok := reopened.Get("a")
+	if !ok || got.Grid != want.Grid || got.Title != want.Title {
+		t.Errorf("reloaded puzzle is %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,186 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Check actions and the cells they apply to.
+const (
+	ActionCheck  = "check"
+	ActionReveal = "reveal"
+
+	ScopeCell   = "cell"
+	ScopeWord   = "word"
+	ScopePuzzle = "puzzle"
+)
+
+// Errors returned for check and reveal requests.
+var (
+	ErrRevealDisabled = errors.New("Reveals are disabled in this room")
+	ErrNoSolution     = errors.New("The puzzle's solution is scrambled or unpublished")
+	ErrNotHost        = errors.New("Only the host can change room settings")
+)
+
+// CheckRequest checks or reveals the cell under the player's cursor, the
+// word it is in, or the whole grid.
+type CheckRequest struct {
+	Action string `json:"action"`
+	Scope  string `json:"scope"`
+}
+
+// RoomSettings are the room options chosen by its host.
+type RoomSettings struct {
+	// Host is the ID of the player who may change the settings.
+	Host           string `json:"host"`
+	DisableReveals bool   `json:"disableReveals"`
+	// StartTimerOnLoad starts the timer as soon as a puzzle loads rather
+	// than on the first entry.
+	StartTimerOnLoad bool `json:"startTimerOnLoad"`
+	// SkipFilled moves the cursor past filled cells when typing.
+	SkipFilled bool `json:"skipFilled"`
+}
+
+func (s *Subscription) handleCheck(input json.RawMessage) error {
+	var request CheckRequest
+	if err := json.Unmarshal([]byte(input), &request); err != nil {
+		return err
+	}
+	return s.actor.call(func(room *Room) error {
+		player := room.players[s.client.id]
+		if player == nil {
+			return errors.New("Player is nil.")
+		}
+		err := room.check(player, request)
+		room.flush()
+		return err
+	})
+}
+
+func (s *Subscription) handleSettings(input json.RawMessage) error {
+	var settings RoomSettings
+	if err := json.Unmarshal([]byte(input), &settings); err != nil {
+		return err
+	}
+	return s.actor.call(func(room *Room) error {
+		if s.client.id != room.settings.Host {
+			return ErrNotHost
+		}
+		room.settings.DisableReveals = settings.DisableReveals
+		room.settings.StartTimerOnLoad = settings.StartTimerOnLoad
+		room.settings.SkipFilled = settings.SkipFilled
+		return room.broadcast(TagSettings, room.settings)
+	})
+}
+
+// check checks or reveals the cells in a request's scope.
+func (r *Room) check(player *Player, request CheckRequest) error {
+	if r.puzzle.Grid == "" {
+		return errors.New("No puzzle is loaded.")
+	}
+	if !r.puzzle.HasSolution() {
+		return ErrNoSolution
+	}
+	if r.solved {
+		return nil
+	}
+	var cells []int
+	switch request.Scope {
+	case ScopeCell:
+		if index, ok := r.cellIndex(player.Position.Row, player.Position.Col); ok {
+			cells = []int{index}
+		}
+	case ScopeWord:
+		cells = r.wordCells(player.Position)
+	case ScopePuzzle:
+		for i := range r.puzzle.Grid {
+			if r.puzzle.Grid[i] != '.' {
+				cells = append(cells, i)
+			}
+		}
+	default:
+		return fmt.Errorf("Unknown check scope %q.", request.Scope)
+	}
+
+	switch request.Action {
+	case ActionCheck:
+		for _, index := range cells {
+			r.checkCell(index)
+		}
+	case ActionReveal:
+		if r.settings.DisableReveals {
+			return ErrRevealDisabled
+		}
+		for _, index := range cells {
+			r.revealCell(index, player.ID)
+		}
+	default:
+		return fmt.Errorf("Unknown check action %q.", request.Action)
+	}
+	return nil
+}
+
+// wordCells returns the cells of the word under a cursor, in its direction.
+func (r *Room) wordCells(position Position) []int {
+	i, ok := r.clueAt(position)
+	if !ok {
+		return nil
+	}
+	return r.clueCells(i)
+}
+
+// checkCell marks a filled cell incorrect if its entry is wrong.
+func (r *Room) checkCell(index int) {
+	if r.state[index] == "" || r.flags[index]&FlagRevealed != 0 {
+		return
+	}
+	if r.isCorrect(index) {
+		r.setFlags(index, r.flags[index]&^FlagIncorrect)
+	} else {
+		r.setFlags(index, r.flags[index]|FlagIncorrect)
+	}
+}
+
+// revealCell fills a cell with its solution and locks it on behalf of a
+// player. The reveal is logged for undo and counted in the player's stats.
+func (r *Room) revealCell(index int, playerID string) {
+	if r.flags[index]&FlagRevealed != 0 || r.isFree(index) {
+		return
+	}
+	edit := cellEdit{
+		Index:     index,
+		Old:       r.state[index],
+		OldPencil: r.isPencil(index),
+		OldAuthor: r.authors[index],
+		OldFlags:  r.flags[index],
+		New:       r.solution(index),
+		PlayerID:  playerID,
+		At:        time.Now(),
+		Reveal:    true,
+	}
+	r.writeReveal(index)
+	r.playerStats(playerID).Revealed++
+	r.logEdit(edit)
+}
+
+// writeReveal fills a cell with its solution and marks it revealed.
+func (r *Room) writeReveal(index int) {
+	flags := r.flags[index]&^(FlagIncorrect|FlagPencil) | FlagRevealed
+	if r.state[index] != "" && !r.isCorrect(index) {
+		flags |= FlagPreviouslyIncorrect
+	}
+	r.startTimer()
+	r.state[index] = r.solution(index)
+	r.authors[index] = ""
+	r.setFlags(index, flags)
+	r.markCell(index)
+}
+
+func (r *Room) setFlags(index int, flags CellFlags) {
+	if r.flags[index] != flags {
+		r.flags[index] = flags
+		r.markCell(index)
+	}
+}
@@ -0,0 +1,75 @@
+package ws
+
+import "testing"
+
+// TestNoSolution checks that a puzzle without a solution can be played but
+// not checked, revealed or completed.
+func TestNoSolution(t *testing.T) {
+	puzzle := testPuzzle("unsolved", "??", 2, 1)
+	puzzle.NoSolution = true
+
+	r := newRoom("unsolved")
+	client := &Client{id: "a", send: make(chan []byte, 256)}
+	r.join(client)
+	r.setPuzzle(puzzle)
+	player := r.players["a"]
+	r.handlePlayerAction(player, "o")
+	r.handlePlayerAction(player, "k")
+	r.flush()
+	for _, action := range []string{ActionCheck, ActionReveal} {
+		if err := r.check(player, CheckRequest{action, ScopePuzzle}); err != ErrNoSolution {
+			t.Errorf("%v: got %v, want ErrNoSolution", action, err)
+		}
+	}
+	if r.state[0] != "O" || r.flags[0] != 0 {
+		t.Errorf("entry changed to %q with flags %v", r.state[0], r.flags[0])
+	}
+	if r.solved || countTag(client, TagComplete, TagNotQuite) != 0 {
+		t.Error("a grid without a solution was judged")
+	}
+}
+
+// TestRevealUndo checks that reveals are logged: the revealer can undo and
+// redo one, an earlier entry under a reveal can't be undone, and the reveal
+// stays in the revealer's stats.
+func TestRevealUndo(t *testing.T) {
+	r := newRoom("reveal")
+	r.setPuzzle(testPuzzle("reveal", "ABCD", 4, 1))
+	typist := &Client{id: "typist", send: make(chan []byte, 256)}
+	revealer := &Client{id: "revealer", send: make(chan []byte, 256)}
+	r.join(typist)
+	r.join(revealer)
+	ann, bob := r.players["typist"], r.players["revealer"]
+	r.handlePlayerAction(ann, "x")
+	bob.Position = Position{0, 0, Across}
+	if err := r.check(bob, CheckRequest{ActionReveal, ScopeCell}); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		player  *Player
+		request UndoRequest
+		value   string
+		flags   CellFlags
+	}{
+		// Ann's entry was replaced by the reveal, so her undo does nothing.
+		{ann, UndoRequest{ActionUndo, ScopePlayer}, "A", FlagRevealed | FlagPreviouslyIncorrect},
+		{bob, UndoRequest{ActionUndo, ScopePlayer}, "X", 0},
+		{bob, UndoRequest{ActionRedo, ScopePlayer}, "A", FlagRevealed | FlagPreviouslyIncorrect},
+		{ann, UndoRequest{ActionUndo, ScopeRoom}, "X", 0},
+	}
+	for i, step := range steps {
+		if err := r.undo(step.player, step.request); err != nil {
+			t.Fatal(err)
+		}
+		if r.state[0] != step.value || r.flags[0] != step.flags {
+			t.Errorf("step %d: cell is %q with flags %#x, want %q with %#x", i, r.state[0], r.flags[0], step.value, step.flags)
+		}
+	}
+	if r.authors[0] != "typist" {
+		t.Errorf("undone reveal left author %q, want typist", r.authors[0])
+	}
+	if stats := r.stats["revealer"]; stats == nil || stats.Revealed != 1 || stats.Letters != 0 {
+		t.Errorf("revealer's stats are %+v, want one reveal", stats)
+	}
+}
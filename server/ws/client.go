@@ -6,14 +6,13 @@ package ws
 
 import (
 	"bytes"
-	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -61,14 +60,34 @@ type PuzzleRequest struct {
 	Day     int      `json:"day"`
 	Month   int      `json:"month"`
 	Year    int      `json:"year"`
+	// Key unlocks a scrambled solution. If empty, every key is tried.
+	Key string `json:"key,omitempty"`
+}
+
+func (r PuzzleRequest) date() time.Time {
+	return time.Date(r.Year, time.Month(r.Month), r.Day, 0, 0, 0, 0, time.UTC)
+}
+
+type PuzzleLoad struct {
+	ID string `json:"id"`
+	// State and Rebus hold a fill saved by the client, in the format sent in
+	// PlayerUpdate.
+	State string         `json:"state"`
+	Rebus map[int]string `json:"rebus,omitempty"`
+	Key   string         `json:"key,omitempty"`
 }
 
 type Register struct {
 	Id string `json:"id"`
+	// Token lets the client reconnect as the same player.
+	Token string `json:"token"`
 }
 
 type PlayerUpdate struct {
-	State   string             `json:"state"`
+	State string         `json:"state"`
+	Rebus map[int]string `json:"rebus,omitempty"`
+	// Flags holds per-cell check and reveal marks, indexed like State.
+	Flags   []CellFlags        `json:"flags,omitempty"`
 	Players map[string]*Player `json:"players"`
 }
 
@@ -77,16 +96,6 @@ type PlayerAction struct {
 	Value    string
 }
 
-type RoomMessage struct {
-	room    string
-	message []byte
-}
-
-type ClientMessage struct {
-	client  *Client
-	message []byte
-}
-
 // Client is a middleman between the websocket connection and the hub.
 type Client struct {
 	hub *Hub
@@ -99,6 +108,19 @@ type Client struct {
 	// Buffered channel of outbound messages.
 	send       chan []byte
 	sendBinary chan []byte
+
+	// resume is the session the client asked to resume when connecting.
+	resume resumeRequest
+
+	// closeSend closes send once, whichever of the room or the hub gives up
+	// on the client first.
+	closeSend sync.Once
+}
+
+func (c *Client) close() {
+	c.closeSend.Do(func() {
+		close(c.send)
+	})
 }
 
 var httpClient = &http.Client{
@@ -122,6 +144,16 @@ const (
 	TagPlayerClick
 	TagPuzzleLoad
 	TagNewPuzzle
+	TagDelta
+	TagSnapshot
+	TagProfile
+	TagCheck
+	TagSettings
+	TagComplete
+	TagNotQuite
+	TagTimer
+	TagSummary
+	TagUndo
 )
 
 // readPump pumps messages from the websocket connection to the hub.
@@ -133,6 +165,11 @@ func (s Subscription) readPump() {
 	c := s.client
 	defer func() {
 		log.Print("Deferred readPump.")
+		// The client leaves the room before the hub is told, so the hub
+		// can close the room once its last client has gone.
+		s.actor.do(func(r *Room) {
+			r.leave(c)
+		})
 		c.hub.unregister <- s
 		c.conn.Close()
 	}()
@@ -170,6 +207,20 @@ func (s Subscription) readPump() {
 			err = s.handleNewPuzzle(msg.Data)
 		case TagPuzzleLoad:
 			err = s.handlePuzzleLoad(msg.Data)
+		case TagSnapshot:
+			err = s.handleSync(msg.Data)
+		case TagProfile:
+			err = s.handleProfile(msg.Data)
+		case TagCheck:
+			err = s.handleCheck(msg.Data)
+		case TagSettings:
+			err = s.handleSettings(msg.Data)
+		case TagTimer:
+			err = s.handleTimer(msg.Data)
+		case TagSummary:
+			err = s.handleSummary()
+		case TagUndo:
+			err = s.handleUndo(msg.Data)
 		}
 
 		// log.Printf("Received type (%s): %s from room %s\n", msg.Type, message, s.room)
@@ -186,162 +237,56 @@ func (s *Subscription) handleText(input json.RawMessage) error {
 	}
 	data := bytes.TrimSpace(bytes.Replace([]byte(text), newline, space, -1))
 	log.Print(string(data))
-	err := s.broadcastToRoom(TagText, string(data))
-	return err
+	return s.actor.call(func(room *Room) error {
+		room.chat = append(room.chat, string(data))
+		if len(room.chat) > maxChatHistory {
+			room.chat = room.chat[len(room.chat)-maxChatHistory:]
+		}
+		message, err := room.logMessage(0, TagText, string(data))
+		if err != nil {
+			return err
+		}
+		room.broadcastMessage(message)
+		return nil
+	})
 }
 
 func (s *Subscription) handlePuzzleRequest(input json.RawMessage) error {
-	message := []byte{}
 	var puzzleRequest PuzzleRequest
 	if err := json.Unmarshal([]byte(input), &puzzleRequest); err != nil {
 		return err
 	}
-
-	url := fmt.Sprintf("https://herbach.dnsalias.com/wsj/wsj%02d%02d%02d.puz",
-		puzzleRequest.Year%100, puzzleRequest.Month, puzzleRequest.Day)
-
-	log.Print(url)
-
-	id := fmt.Sprintf("%v-%v-%v-%v",
-		puzzleRequest.Sources[0],
-		puzzleRequest.Year,
-		puzzleRequest.Month,
-		puzzleRequest.Day)
-
-	resp, err := httpClient.Get(url)
-	if err != nil {
-		log.Printf("Error %v", err)
+	if len(puzzleRequest.Sources) == 0 {
+		return errors.New("No puzzle sources requested.")
 	}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("Error %v", err)
+	// Load the first requested source that has a puzzle on the date. This
+	// happens outside the room so a slow download doesn't hold it up.
+	var puzzle Puzzle
+	var err error
+	for _, source := range puzzleRequest.Sources {
+		puzzle, err = fetchPuzzle(source, puzzleRequest.date(), puzzleRequest.Key)
+		if err == nil {
+			break
+		}
+		log.Printf("Error fetching %v: %v", source, err)
 	}
-
-	resp.Body.Close()
-	message = body
-
-	// // Offline
-	// err = os.WriteFile("ws/wsj.puz", body, 0644)
-	// if err != nil {
-	// 	log.Printf("Error %v", err)
-	// }
-	// offlineMessage, err := os.ReadFile("ws/wsj.puz")
-	// if err != nil {
-	// 	return err
-	// }
-	// log.Print(len(message))
-
-	// log.Print(string(message) == string(offlineMessage))
-	// message = offlineMessage
-
-	puzzle, err := parsePuz(message, id)
 	if err != nil {
 		return err
 	}
 
 	log.Printf("%#v", puzzle)
-	room := GlobalHub.rooms[s.room]
-	room.puzzle = puzzle.Grid
-	room.state = make([]byte, len(puzzle.Grid))
-	room.height = puzzle.Height
-	room.width = puzzle.Width
-
-	err = s.broadcastToRoom(TagPuzzle, puzzle)
-	return err
-}
-
-func parsePuz(data []byte, id string) (Puzzle, error) {
-	width := int(data[44])
-	height := int(data[45])
-	n := width * height
-	log.Printf("%v x %v", width, height)
-
-	gextIndex := bytes.Index(data[52+2*n:], []byte("GEXT"))
-
-	var stringsSection []byte
-	var gextSection []byte
-	if gextIndex != -1 {
-		stringsSection = util.Utf8(data[52+2*n : 52+2*n+gextIndex])
-		gextSection = data[52+2*n+gextIndex:]
-	} else {
-		stringsSection = util.Utf8(data[52+2*n:])
-		gextSection = []byte{}
-	}
-
-	clueString := string(stringsSection)
-	lines := strings.Split(clueString, "\u0000")
-
-	clueLines := lines[3 : len(lines)-1]
-	for i, clue := range clueLines {
-		log.Printf("[%v] %#v (%v)", i, clue, []byte(clue))
-	}
-
-	var acrossClues []Clue
-	var downClues []Clue
-	num := 1
-	clueIndex := 0
-
-	grid := string(data[52 : 52+n])
-	for row := 0; row < int(height); row++ {
-		for col := 0; col < int(width); col++ {
-			index := row*int(width) + col
-			if grid[index] == '.' {
-				continue
-			}
-			clue := Clue{
-				Number: num,
-				Row:    row,
-				Column: col,
-			}
-			hasClue := false
-			// Across clue.
-			if col == 0 || grid[index-1] == '.' {
-				clue.Text = clueLines[clueIndex]
-				clue.Direction = Across
-				for k := col; k < width && grid[row*width+k] != '.'; k++ {
-					clue.Length++
-				}
-				acrossClues = append(acrossClues, clue)
-				clueIndex++
-				hasClue = true
-			}
-			// Down clue.
-			if row == 0 || grid[index-int(width)] == '.' {
-				clue.Length = 0
-				clue.Text = clueLines[clueIndex]
-				clue.Direction = Down
-				for k := row; k < height && grid[k*width+col] != '.'; k++ {
-					clue.Length++
-				}
-				downClues = append(downClues, clue)
-				clueIndex++
-				hasClue = true
-			}
-			if hasClue {
-				num++
-			}
+	return s.actor.call(func(room *Room) error {
+		room.setPuzzle(puzzle)
+		if err := room.broadcast(TagPuzzle, puzzle); err != nil {
+			return err
 		}
-	}
-
-	for i, clue := range acrossClues {
-		log.Printf("[%v] %#v", i, clue)
-	}
-
-	puzzle := Puzzle{
-		ID:          id,
-		Width:       width,
-		Height:      height,
-		NumClues:    int(binary.LittleEndian.Uint16(data[46:48])),
-		Grid:        grid,
-		AcrossClues: acrossClues,
-		DownClues:   downClues,
-		Title:       lines[0],
-		Creators:    lines[1],
-		Attribution: lines[2],
-		Gext:        string(gextSection),
-	}
-	return puzzle, nil
+		// Send any fill saved from an earlier visit to the puzzle.
+		if err := room.broadcastState(); err != nil {
+			return err
+		}
+		return room.loadTimer()
+	})
 }
 
 func (s *Subscription) handlePlayerAction(input json.RawMessage) error {
@@ -350,79 +295,147 @@ func (s *Subscription) handlePlayerAction(input json.RawMessage) error {
 		return err
 	}
 	log.Printf("handlePlayerAction: %v", key)
-	room := GlobalHub.rooms[s.room]
-	if room == nil {
-		return errors.New("Room is nil.")
-	}
-	player := room.players[s.client.id]
-	if player == nil {
-		return errors.New("Player is nil.")
-	}
+	return s.actor.call(func(room *Room) error {
+		player := room.players[s.client.id]
+		if player == nil {
+			return errors.New("Player is nil.")
+		}
+		err := room.handlePlayerAction(player, string(key))
+		room.flush()
+		return err
+	})
+}
+
+func (r *Room) handlePlayerAction(player *Player, key string) error {
 	row := player.Position.Row
 	col := player.Position.Col
 	dir := player.Position.Dir
 
-	switch string(key) {
-	case KeySpace:
-		s.setPlayerPosition(row, col, dir.flip())
-	case KeyBackspace, KeyDelete:
-		// s.setCellValue(row, col, ' ')
-		room.state[row*room.width+col] = 0
-		if dir == Across {
-			s.setPlayerPosition(row, col-1, dir)
-		} else {
-			s.setPlayerPosition(row-1, col, dir)
+	switch key {
+	case ActionPencil:
+		player.PencilMode = !player.PencilMode
+		r.markPlayer(player.ID)
+		return nil
+	case ActionCommitPencil:
+		r.commitPencil(player)
+		return nil
+	}
+
+	if player.RebusMode {
+		return r.handleRebusAction(player, key)
+	}
+
+	switch key {
+	case KeyInsert:
+		if _, ok := r.cellIndex(row, col); !ok {
+			return errors.New("Position is out of bounds.")
 		}
+		player.RebusMode = true
+		player.RebusEntry = ""
+		r.setPlayerPosition(player, row, col, dir)
+	case KeySpace:
+		r.setPlayerPosition(player, row, col, dir.Flip())
+	case KeyBackspace:
+		r.backspace(player)
+	case KeyDelete:
+		r.setCellValue(row, col, "", player)
+		r.setPlayerPosition(player, row, col, dir)
+	case KeyTab:
+		r.jumpClue(player, 1)
+	case KeyShiftTab:
+		r.jumpClue(player, -1)
+	case KeyHome:
+		r.jumpWord(player, false)
+	case KeyEnd:
+		r.jumpWord(player, true)
 	case KeyArrowDown:
-		s.setPlayerPosition(row+1, col, dir)
+		r.setPlayerPosition(player, row+1, col, dir)
 	case KeyArrowLeft:
-		s.setPlayerPosition(row, col-1, dir)
+		r.setPlayerPosition(player, row, col-1, dir)
 	case KeyArrowRight:
-		s.setPlayerPosition(row, col+1, dir)
+		r.setPlayerPosition(player, row, col+1, dir)
 	case KeyArrowUp:
-		s.setPlayerPosition(row-1, col, dir)
+		r.setPlayerPosition(player, row-1, col, dir)
 	default:
 		if len(key) == 1 {
 			code := key[0]
 			if code < 97 || code > 122 {
 				return errors.New("Key code is not a lowercase letter.")
 			}
-			room.state[row*room.width+col] = code - 32
+			r.setCellValue(row, col, string(code-32), player)
 			log.Printf("code: %v %v", string(code), string(code-32))
-			log.Printf(string(room.state))
-			if dir == Across {
-				s.setPlayerPosition(row, col+1, dir)
-			} else {
-				s.setPlayerPosition(row+1, col, dir)
-			}
+			r.advance(player)
 		}
 	}
 
 	return nil
 }
 
+// handleRebusAction handles a key pressed while the player is composing a
+// multi-letter entry. Enter commits the entry to the cell and Escape discards
+// it.
+func (r *Room) handleRebusAction(player *Player, key string) error {
+	row := player.Position.Row
+	col := player.Position.Col
+	dir := player.Position.Dir
+
+	switch key {
+	case KeyEnter:
+		entry := player.RebusEntry
+		if entry != "" && !validRebusEntry(entry) {
+			return fmt.Errorf("Invalid rebus entry %q.", entry)
+		}
+		player.RebusMode = false
+		player.RebusEntry = ""
+		r.setCellValue(row, col, entry, player)
+		r.advance(player)
+	case KeyEscape:
+		player.RebusMode = false
+		player.RebusEntry = ""
+		r.setPlayerPosition(player, row, col, dir)
+	case KeyBackspace, KeyDelete:
+		if n := len(player.RebusEntry); n > 0 {
+			player.RebusEntry = player.RebusEntry[:n-1]
+		}
+		r.setPlayerPosition(player, row, col, dir)
+	default:
+		if len(key) != 1 {
+			return nil
+		}
+		code := key[0]
+		if (code < 'a' || code > 'z') && (code < '0' || code > '9') {
+			return errors.New("Key code is not a lowercase letter or digit.")
+		}
+		if len(player.RebusEntry) >= maxRebusLength {
+			return errors.New("Rebus entry is too long.")
+		}
+		player.RebusEntry += strings.ToUpper(key)
+		r.setPlayerPosition(player, row, col, dir)
+	}
+	return nil
+}
+
 func (s *Subscription) handlePlayerClick(input json.RawMessage) error {
 	var position Position
 	if err := json.Unmarshal([]byte(input), &position); err != nil {
 		return err
 	}
 	log.Printf("handlePlayerClick: %#v", position)
-	room := GlobalHub.rooms[s.room]
-	if room == nil {
-		return errors.New("Room is nil.")
-	}
-	player := room.players[s.client.id]
-	if player == nil {
-		return errors.New("Player is nil.")
-	}
-	row := position.Row
-	col := position.Col
-	if row == player.Position.Row && col == player.Position.Col {
-		s.setPlayerPosition(row, col, player.Position.Dir.flip())
-	} else {
-		s.setPlayerPosition(row, col, player.Position.Dir)
-	}
-	return nil
+	return s.actor.call(func(room *Room) error {
+		player := room.players[s.client.id]
+		if player == nil {
+			return errors.New("Player is nil.")
+		}
+		row := position.Row
+		col := position.Col
+		if row == player.Position.Row && col == player.Position.Col {
+			room.setPlayerPosition(player, row, col, player.Position.Dir.Flip())
+		} else {
+			room.setPlayerPosition(player, row, col, player.Position.Dir)
+		}
+		room.flush()
+		return nil
+	})
 }
 
 func (s *Subscription) handleNewPuzzle(input json.RawMessage) error {
@@ -431,45 +444,26 @@ func (s *Subscription) handleNewPuzzle(input json.RawMessage) error {
 	if err := json.Unmarshal([]byte(input), &puzzleRequest); err != nil {
 		return err
 	}
+	var puzzles []Puzzle
 	var puzzleData []PuzzleData
 
 	for _, source := range puzzleRequest.Sources {
-		url := fmt.Sprintf("https://herbach.dnsalias.com/wsj/wsj%02d%02d%02d.puz",
-			puzzleRequest.Year%100, puzzleRequest.Month, puzzleRequest.Day)
-
-		log.Print(url)
-
-		id := fmt.Sprintf("%v-%v-%v-%v",
-			source,
-			puzzleRequest.Year,
-			puzzleRequest.Month,
-			puzzleRequest.Day)
-
-		var puzzle Puzzle
-		puzzle, ok := GlobalPuzzleCache[id]
-		if !ok {
-			resp, err := httpClient.Get(url)
-			if err != nil {
-				log.Printf("Error %v", err)
-			}
-			if resp.StatusCode != http.StatusOK {
-				log.Printf("Puzzle not found")
-				continue
-			}
-			message, err := io.ReadAll(resp.Body)
-			if err != nil {
-				log.Printf("Error %v", err)
-			}
-			resp.Body.Close()
-			puzzle, err = parsePuz(message, id)
-			if err != nil {
-				return err
-			}
-			GlobalPuzzleCache[id] = puzzle
+		// Without a date, list everything held by catalog sources.
+		if puzzleRequest.Year == 0 {
+			listed, data := catalogPuzzleData(source, puzzleRequest.Key)
+			puzzles = append(puzzles, listed...)
+			puzzleData = append(puzzleData, data...)
+			continue
+		}
+		puzzle, err := fetchPuzzle(source, puzzleRequest.date(), puzzleRequest.Key)
+		if err != nil {
+			log.Printf("Error fetching %v: %v", source, err)
+			continue
 		}
 
+		puzzles = append(puzzles, puzzle)
 		puzzleData = append(puzzleData, PuzzleData{
-			ID:     id,
+			ID:     puzzle.ID,
 			Source: source,
 			Year:   puzzleRequest.Year,
 			Month:  puzzleRequest.Month,
@@ -477,55 +471,157 @@ func (s *Subscription) handleNewPuzzle(input json.RawMessage) error {
 			Title:  puzzle.Title,
 		})
 	}
-	err := s.broadcastToRoom(TagNewPuzzle, puzzleData)
-	return err
-}
-
-func (s *Subscription) handlePuzzleLoad(input json.RawMessage) error {
-	return nil
+	return s.actor.call(func(room *Room) error {
+		for i := range puzzleData {
+			room.addProgress(&puzzleData[i], puzzles[i])
+		}
+		return room.broadcast(TagNewPuzzle, puzzleData)
+	})
 }
 
-func (s *Subscription) setCellValue(row, col int, value byte) {
-	room := GlobalHub.rooms[s.room]
-	if room == nil {
-		return
+// catalogPuzzleData lists the puzzles held by a catalog source.
+func catalogPuzzleData(sourceID, key string) ([]Puzzle, []PuzzleData) {
+	source, ok := GlobalSources.Get(sourceID)
+	if !ok {
+		log.Printf("Error listing %v: %v", sourceID, ErrUnknownSource)
+		return nil, nil
+	}
+	catalog, ok := source.(Catalog)
+	if !ok {
+		return nil, nil
+	}
+	var puzzles []Puzzle
+	var puzzleData []PuzzleData
+	for _, entry := range catalog.Entries() {
+		puzzle, err := fetchCatalogPuzzle(catalog, entry.ID, key)
+		if err != nil {
+			log.Printf("Error fetching %v: %v", entry.ID, err)
+			continue
+		}
+		data := PuzzleData{
+			ID:     puzzle.ID,
+			Source: sourceID,
+			Title:  puzzle.Title,
+		}
+		if !entry.Date.IsZero() {
+			data.Year = entry.Date.Year()
+			data.Month = int(entry.Date.Month())
+			data.Day = entry.Date.Day()
+		}
+		puzzles = append(puzzles, puzzle)
+		puzzleData = append(puzzleData, data)
 	}
-	index := row*room.width + col
-	room.state[index] = value
+	return puzzles, puzzleData
 }
 
-func (s *Subscription) broadcastToRoom(tag MessageTag, data interface{}) error {
-	message := TaggedMessage{tag, data}
-	encoded, err := json.Marshal(message)
+// handlePuzzleLoad switches the room to a puzzle listed by TagNewPuzzle. The
+// room keeps its fill if the puzzle is already active, and otherwise restores
+// the fill sent by the client, if any.
+func (s *Subscription) handlePuzzleLoad(input json.RawMessage) error {
+	var puzzleLoad PuzzleLoad
+	if err := json.Unmarshal([]byte(input), &puzzleLoad); err != nil {
+		return err
+	}
+	puzzle, err := fetchPuzzleByID(puzzleLoad.ID, puzzleLoad.Key)
 	if err != nil {
 		return err
 	}
-	s.client.hub.broadcast <- RoomMessage{s.room, encoded}
-	return nil
+	return s.actor.call(func(room *Room) error {
+		if room.puzzle.ID != puzzle.ID || room.puzzle.Grid == "" {
+			// The room's own saved work takes precedence over the client's.
+			if !room.setPuzzle(puzzle) && puzzleLoad.State != "" && !room.restoreState(puzzleLoad.State, puzzleLoad.Rebus) {
+				log.Printf("Ignoring state of length %v for %v.", len(puzzleLoad.State), puzzle.ID)
+			}
+		}
+		if err := room.broadcast(TagPuzzle, room.puzzle); err != nil {
+			return err
+		}
+		if err := room.broadcastState(); err != nil {
+			return err
+		}
+		return room.loadTimer()
+	})
 }
 
-func (s *Subscription) setPlayerPosition(row, col int, dir Direction) {
-	room := GlobalHub.rooms[s.room]
-	if room == nil {
-		log.Print("room is nil")
+// setCellValue fills in a cell on behalf of a player, in pencil if the
+// player is in pencil mode.
+func (r *Room) setCellValue(row, col int, value string, player *Player) {
+	index, ok := r.cellIndex(row, col)
+	if !ok || index >= len(r.state) || !r.editable(index) {
 		return
 	}
-	w := room.width
-	h := room.height
-	if row < 0 || col < 0 || row >= h || col >= w {
-		log.Print("position out of bounds")
+	pencil := player.PencilMode && value != ""
+	if r.state[index] == value && r.isPencil(index) == pencil {
 		return
 	}
+	edit := cellEdit{
+		Index:     index,
+		Old:       r.state[index],
+		OldPencil: r.isPencil(index),
+		OldAuthor: r.authors[index],
+		New:       value,
+		NewPencil: pencil,
+		PlayerID:  player.ID,
+		At:        time.Now(),
+	}
+	entered := value != "" && value != r.state[index]
+	firstTry := r.enteredAt[index].IsZero() && r.state[index] == ""
+	wasCorrect := r.state[index] != "" && r.isCorrect(index)
+	r.writeCell(index, value, pencil, player.ID)
+	if entered {
+		r.enteredAt[index] = edit.At
+		r.recordEntry(player.ID, index, firstTry, wasCorrect)
+	}
+	r.logEdit(edit)
+}
 
-	player := room.players[s.client.id]
-	if player == nil {
-		log.Print("player is nil")
+// editable reports whether a cell may be changed. Revealed cells and solved
+// grids are locked.
+func (r *Room) editable(index int) bool {
+	return !r.solved && r.flags[index]&FlagRevealed == 0
+}
+
+func (r *Room) isPencil(index int) bool {
+	return r.flags[index]&FlagPencil != 0
+}
+
+// writeCell sets a cell's entry and author. The change is sent to the room
+// on the next flush.
+func (r *Room) writeCell(index int, value string, pencil bool, author string) {
+	flags := r.flags[index] &^ FlagPencil
+	if pencil {
+		flags |= FlagPencil
+	}
+	if flags&FlagIncorrect != 0 {
+		flags = flags&^FlagIncorrect | FlagPreviouslyIncorrect
+	}
+	r.startTimer()
+	r.setFlags(index, flags)
+	r.state[index] = value
+	r.authors[index] = author
+	if value == "" {
+		r.authors[index] = ""
+	}
+	r.markCell(index)
+}
+
+// setPlayerPosition moves a player's cursor, skipping over blocks. The change
+// is sent to the room on the next flush.
+func (r *Room) setPlayerPosition(player *Player, row, col int, dir Direction) {
+	// Rebus entries may have changed even if the cursor can't move.
+	r.markPlayer(player.ID)
+	r.noteActivity()
+	w := r.width
+	h := r.height
+	if row < 0 || col < 0 || row >= h || col >= w {
+		log.Print("position out of bounds")
 		return
 	}
+
 	currentRow := player.Position.Row
 	currentCol := player.Position.Col
 
-	for room.puzzle[row*w+col] == '.' {
+	for r.puzzle.Grid[row*w+col] == '.' {
 		if col == currentCol {
 			if row > currentRow && row < h-1 {
 				row += 1
@@ -542,30 +638,14 @@ func (s *Subscription) setPlayerPosition(row, col int, dir Direction) {
 			} else {
 				return
 			}
+		} else {
+			// A block away from the player's row and column, such as a
+			// clicked one, can't be skipped over.
+			return
 		}
 	}
 
 	player.Position = Position{row, col, dir}
-	taggedMessage := TaggedMessage{
-		Tag: TagPlayerUpdate,
-		Data: PlayerUpdate{
-			string(room.state),
-			room.players,
-		},
-	}
-
-	log.Print(string(room.state))
-
-	output, err := json.Marshal(taggedMessage)
-	if err != nil {
-		return
-	}
-
-	s.client.hub.broadcastMessage(room, "", output)
-
-	for id, p := range room.players {
-		log.Printf("%v: %v", id, p.Position)
-	}
 }
 
 // writePump pumps messages from the hub to the websocket connection.
@@ -636,11 +716,24 @@ func ServeWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
 		id:   util.NewId(8),
 		conn: conn,
 		send: make(chan []byte, 256),
+
+		resume: parseResumeRequest(r.URL.Query()),
+	}
+	// The query holds the client's session token, so only the path is logged.
+	log.Printf("Connecting %v to %v.", client.id, r.URL.Path)
+	subscription := &Subscription{
+		client:   client,
+		room:     r.URL.Path,
+		assigned: make(chan struct{}),
 	}
-	log.Println(r.URL)
-	log.Printf("%#v", client)
-	subscription := Subscription{client, r.URL.Path}
 	client.hub.register <- subscription
+	<-subscription.assigned
+	// The room has at least this client as a member, so it can't stop
+	// before the client joins.
+	subscription.actor.call(func(room *Room) error {
+		room.join(client)
+		return nil
+	})
 
 	// Allow collection of memory referenced by the caller by doing all work in
 	// new goroutines.
@@ -0,0 +1,175 @@
+package ws
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// TestRebusEntry checks that a multi-letter entry is composed after Insert,
+// and only written to the cell when committed with Enter.
+func TestRebusEntry(t *testing.T) {
+	tests := []struct {
+		name  string
+		keys  string
+		value string
+		col   int
+		mode  bool
+		err   bool
+	}{
+		{"commit", "Insert h e a r t Enter", "HEART", 1, false, false},
+		{"digits", "Insert 1 2 Enter", "12", 1, false, false},
+		{"compose", "Insert h e", "X", 0, true, false},
+		{"escape", "Insert h e Escape", "X", 0, false, false},
+		{"backspace", "Insert h x Backspace e Enter", "HE", 1, false, false},
+		{"empty", "Insert Enter", "", 1, false, false},
+		{"symbol", "Insert h ! Enter", "H", 1, false, true},
+		{"too long", "Insert a b c d e f g h i j k Enter", "ABCDEFGHIJ", 1, false, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			puzzle := testPuzzle("rebus", "HBC", 3, 1)
+			puzzle.Rebus = map[int]string{0: "HEART"}
+			r := newRoom("rebus")
+			r.setPuzzle(puzzle)
+			r.join(&Client{id: "a", send: make(chan []byte, 256)})
+			player := r.players["a"]
+			r.handlePlayerAction(player, "x")
+			player.Position = Position{0, 0, Across}
+
+			failed := false
+			for _, key := range strings.Fields(test.keys) {
+				if err := r.handlePlayerAction(player, key); err != nil {
+					failed = true
+				}
+			}
+			if failed != test.err {
+				t.Errorf("got an error: %v, want %v", failed, test.err)
+			}
+			if r.state[0] != test.value {
+				t.Errorf("cell holds %q, want %q", r.state[0], test.value)
+			}
+			if player.Position.Col != test.col || player.RebusMode != test.mode {
+				t.Errorf("player at column %d in rebus mode %v, want %d and %v",
+					player.Position.Col, player.RebusMode, test.col, test.mode)
+			}
+			// The first letter of a rebus answer is also accepted.
+			if want := test.value == "HEART" || test.value == "H"; r.isCorrect(0) != want {
+				t.Errorf("cell correct: %v, want %v", r.isCorrect(0), want)
+			}
+		})
+	}
+}
+
+// TestPuzzleLoad checks that loading a puzzle by ID installs it in the room,
+// restoring the room's own work on it over a fill sent by the client, and
+// leaves the room alone when the puzzle can't be found.
+func TestPuzzleLoad(t *testing.T) {
+	puzzle := setupGlobals(t)
+	first := strings.IndexFunc(puzzle.Grid, func(c rune) bool { return c != '.' })
+	// clientState is a fill sent by a client, with X in the first cell.
+	clientState := []byte(strings.Repeat(" ", len(puzzle.Grid)))
+	clientState[first] = 'x'
+
+	tests := []struct {
+		name  string
+		setup func(r *Room, player *Player)
+		load  PuzzleLoad
+		id    string
+		entry string
+		err   bool
+	}{
+		{
+			name:  "fresh",
+			load:  PuzzleLoad{ID: puzzle.ID},
+			id:    puzzle.ID,
+			entry: "",
+		},
+		{
+			name:  "client fill",
+			load:  PuzzleLoad{ID: puzzle.ID, State: string(clientState)},
+			id:    puzzle.ID,
+			entry: "X",
+		},
+		{
+			name:  "client rebus",
+			load:  PuzzleLoad{ID: puzzle.ID, State: string(clientState), Rebus: map[int]string{first: "xy"}},
+			id:    puzzle.ID,
+			entry: "XY",
+		},
+		{
+			name:  "client fill size",
+			load:  PuzzleLoad{ID: puzzle.ID, State: "x"},
+			id:    puzzle.ID,
+			entry: "",
+		},
+		{
+			name: "saved work",
+			setup: func(r *Room, player *Player) {
+				r.setPuzzle(puzzle)
+				r.writeCell(first, "Q", false, player.ID)
+				r.setPuzzle(testPuzzle("other", "AB", 2, 1))
+			},
+			load:  PuzzleLoad{ID: puzzle.ID, State: string(clientState)},
+			id:    puzzle.ID,
+			entry: "Q",
+		},
+		{
+			name: "already showing",
+			setup: func(r *Room, player *Player) {
+				r.setPuzzle(puzzle)
+				r.writeCell(first, "Q", false, player.ID)
+			},
+			load:  PuzzleLoad{ID: puzzle.ID, State: string(clientState)},
+			id:    puzzle.ID,
+			entry: "Q",
+		},
+		{
+			name: "unknown",
+			setup: func(r *Room, player *Player) {
+				r.setPuzzle(testPuzzle("other", "AB", 2, 1))
+				r.writeCell(0, "Q", false, player.ID)
+			},
+			load:  PuzzleLoad{ID: "none-2021-7-31"},
+			id:    "other",
+			entry: "Q",
+			err:   true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newRoom("load")
+			go r.run()
+			defer close(r.quit)
+			client := &Client{id: "a", send: make(chan []byte, 256)}
+			r.call(func(r *Room) error {
+				r.join(client)
+				if test.setup != nil {
+					test.setup(r, r.players["a"])
+				}
+				drainClient(client)
+				return nil
+			})
+
+			input, _ := json.Marshal(test.load)
+			s := &Subscription{client: client, room: "load", actor: r}
+			if err := s.handlePuzzleLoad(input); (err != nil) != test.err {
+				t.Fatalf("got error %v, want an error: %v", err, test.err)
+			}
+			r.call(func(r *Room) error {
+				index := first
+				if test.id != puzzle.ID {
+					index = 0
+				}
+				if r.puzzle.ID != test.id || r.state[index] != test.entry {
+					t.Errorf("room shows %q with %q in cell %d, want %q with %q",
+						r.puzzle.ID, r.state[index], index, test.id, test.entry)
+				}
+				if sent := countTag(client, TagPuzzle) > 0; sent == test.err {
+					t.Errorf("puzzle sent: %v, want %v", sent, !test.err)
+				}
+				return nil
+			})
+		})
+	}
+}
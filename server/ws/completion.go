@@ -0,0 +1,134 @@
+package ws
+
+import (
+	"sort"
+	"time"
+)
+
+// Completion summarizes the work on a puzzle. It is broadcast when the grid
+// is filled in correctly, and sent on request at any time.
+type Completion struct {
+	// Elapsed is the solving time in seconds.
+	Elapsed       int            `json:"elapsed"`
+	Solved        bool           `json:"solved"`
+	Contributions []Contribution `json:"contributions"`
+	// Revealed is the number of cells filled in by reveals.
+	Revealed int `json:"revealed"`
+	// Entries holds who last entered each filled cell, and when.
+	Entries []CellEntry `json:"entries"`
+}
+
+// CellEntry is the last entry in a cell.
+type CellEntry struct {
+	Index     int       `json:"index"`
+	PlayerID  string    `json:"playerId"`
+	EnteredAt time.Time `json:"enteredAt"`
+}
+
+// Contribution is the number of correct cells a player filled in, or of all
+// their cells if the solution isn't known, along with the player's entry
+// stats.
+type Contribution struct {
+	PlayerID string `json:"playerId"`
+	Name     string `json:"name"`
+	Cells    int    `json:"cells"`
+	PlayerStats
+}
+
+// isFilled reports whether every cell that needs a letter has an entry.
+func (r *Room) isFilled() bool {
+	if r.puzzle.Grid == "" || len(r.state) != len(r.puzzle.Grid) {
+		return false
+	}
+	for i := range r.state {
+		if r.puzzle.Grid[i] != '.' && !r.isFree(i) && r.state[i] == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// checkCompletion announces when the grid is first filled in. A correct fill
+// stops the timer and locks the grid; an incorrect one gets a "not quite".
+func (r *Room) checkCompletion() {
+	if r.solved {
+		return
+	}
+	filled := r.isFilled()
+	wasFilled := r.filled
+	r.filled = filled
+	if !filled || !r.puzzle.HasSolution() {
+		// A grid whose solution isn't known can't be judged.
+		return
+	}
+	if r.isSolved() {
+		r.solved = true
+		r.pauseTimer(time.Now(), PausedSolved)
+		message, err := r.logMessage(0, TagComplete, r.completion())
+		if err == nil {
+			r.broadcastMessage(message)
+		}
+		r.persist()
+		return
+	}
+	if !wasFilled {
+		message, err := r.logMessage(0, TagNotQuite, nil)
+		if err == nil {
+			r.broadcastMessage(message)
+		}
+	}
+}
+
+// completion summarizes the grid, crediting each correct cell to the player
+// who filled it in.
+func (r *Room) completion() Completion {
+	counts := make(map[string]int)
+	revealed := 0
+	for i, author := range r.authors {
+		switch {
+		case r.flags[i]&FlagRevealed != 0:
+			revealed++
+		case author != "" && (!r.puzzle.HasSolution() || r.isCorrect(i)):
+			counts[author]++
+		}
+	}
+	for id := range r.stats {
+		if _, ok := counts[id]; !ok {
+			counts[id] = 0
+		}
+	}
+	completion := Completion{
+		Elapsed:  int(r.elapsed().Seconds()),
+		Solved:   r.solved,
+		Revealed: revealed,
+		Entries:  []CellEntry{},
+	}
+	for i, author := range r.authors {
+		if author != "" && i < len(r.enteredAt) && !r.enteredAt[i].IsZero() {
+			completion.Entries = append(completion.Entries, CellEntry{i, author, r.enteredAt[i]})
+		}
+	}
+	for id, cells := range counts {
+		contribution := Contribution{PlayerID: id, Cells: cells}
+		if stats, ok := r.stats[id]; ok {
+			contribution.PlayerStats = *stats
+		}
+		if player, ok := r.players[id]; ok {
+			contribution.Name = player.Name
+		} else if player, ok := r.departed[id]; ok {
+			contribution.Name = player.Name
+		}
+		completion.Contributions = append(completion.Contributions, contribution)
+	}
+	sort.Slice(completion.Contributions, func(i, j int) bool {
+		a, b := completion.Contributions[i], completion.Contributions[j]
+		if a.Cells != b.Cells {
+			return a.Cells > b.Cells
+		}
+		if a.Letters != b.Letters {
+			return a.Letters > b.Letters
+		}
+		return a.PlayerID < b.PlayerID
+	})
+	return completion
+}
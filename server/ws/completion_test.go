@@ -0,0 +1,50 @@
+package ws
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/tmngo/crossword-server/format"
+)
+
+func testPuzzle(id, grid string, width, height int) Puzzle {
+	puzzle := Puzzle{ID: id, Grid: grid, Width: width, Height: height}
+	puzzle.AcrossClues, puzzle.DownClues, _ = format.NumberClues(grid, width, height, nil)
+	return puzzle
+}
+
+// countTag drains the messages queued for a client, and returns the number
+// with any of the given tags.
+func countTag(client *Client, tags ...MessageTag) int {
+	n := 0
+	for message := range drainClient(client) {
+		var msg Message
+		json.Unmarshal(message, &msg)
+		for _, tag := range tags {
+			if msg.Tag == tag {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// TestNotQuiteAfterPuzzleChange checks that a wrong fill is announced on a
+// puzzle loaded after another wrongly filled one.
+func TestNotQuiteAfterPuzzleChange(t *testing.T) {
+	r := newRoom("complete")
+	client := &Client{id: "a", send: make(chan []byte, 256)}
+	r.join(client)
+	player := r.players["a"]
+	for _, puzzle := range []Puzzle{testPuzzle("one", "AB", 2, 1), testPuzzle("two", "CD", 2, 1)} {
+		r.setPuzzle(puzzle)
+		r.setPlayerPosition(player, 0, 0, Across)
+		countTag(client, TagNotQuite)
+		r.handlePlayerAction(player, "x")
+		r.handlePlayerAction(player, "x")
+		r.flush()
+		if n := countTag(client, TagNotQuite); n != 1 {
+			t.Errorf("puzzle %v: got %v not-quite messages, want 1", puzzle.ID, n)
+		}
+	}
+}
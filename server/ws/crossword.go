@@ -1,18 +1,34 @@
 package ws
 
-type Puzzle struct {
-	ID          string `json:"id"`
-	Width       int    `json:"width"`
-	Height      int    `json:"height"`
-	NumClues    int    `json:"numClues"`
-	Grid        string `json:"grid"`
-	AcrossClues []Clue `json:"acrossClues"`
-	DownClues   []Clue `json:"downClues"`
-	Title       string `json:"title"`
-	Creators    string `json:"creator"`
-	Attribution string `json:"attribution"`
-	Gext        string `json:"gext"`
-}
+import "github.com/tmngo/crossword-server/format"
+
+// The puzzle types are defined by the format package, which reads and writes
+// puzzle files.
+type (
+	Puzzle      = format.Puzzle
+	Clue        = format.Clue
+	Direction   = format.Direction
+	CellFlags   = format.CellFlags
+	PuzzleTimer = format.PuzzleTimer
+)
+
+const (
+	Across = format.Across
+	Down   = format.Down
+)
+
+const (
+	FlagPreviouslyIncorrect = format.FlagPreviouslyIncorrect
+	FlagIncorrect           = format.FlagIncorrect
+	FlagRevealed            = format.FlagRevealed
+	FlagCircled             = format.FlagCircled
+	FlagShaded              = format.FlagShaded
+	FlagBarTop              = format.FlagBarTop
+	FlagBarLeft             = format.FlagBarLeft
+
+	// FlagPencil marks a tentative entry. It is only kept in room state.
+	FlagPencil CellFlags = 0x800
+)
 
 type PuzzleData struct {
 	ID         string  `json:"id"`
@@ -25,15 +41,6 @@ type PuzzleData struct {
 	Completion float64 `json:"completion"`
 }
 
-type Clue struct {
-	Number    int       `json:"number"`
-	Text      string    `json:"text"`
-	Direction Direction `json:"direction"`
-	Row       int       `json:"row"`
-	Column    int       `json:"column"`
-	Length    int       `json:"length"`
-}
-
 type Color struct {
 	R float64 `json:"r"`
 	G float64 `json:"g"`
@@ -41,22 +48,17 @@ type Color struct {
 	A float64 `json:"a"`
 }
 
-type Direction int
-
-const (
-	Across Direction = iota
-	Down
-)
-
-func (d Direction) flip() Direction {
-	return 1 - d
-}
-
 type Player struct {
 	Name     string   `json:"name"`
 	ID       string   `json:"id"`
 	Color    Color    `json:"color"`
 	Position Position `json:"position"`
+	// RebusMode is set while the player composes a multi-letter entry for
+	// the cell at Position.
+	RebusMode  bool   `json:"rebusMode"`
+	RebusEntry string `json:"rebusEntry"`
+	// PencilMode is set while the player's entries are tentative.
+	PencilMode bool `json:"pencilMode"`
 }
 
 type Position struct {
@@ -0,0 +1,220 @@
+package ws
+
+import (
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tmngo/crossword-server/format"
+)
+
+// Catalog is implemented by sources that can list the puzzles they hold,
+// including puzzles without a publication date.
+type Catalog interface {
+	Source
+	// Entries returns the source's puzzles, ordered by date and then name.
+	Entries() []CatalogEntry
+	// FetchID returns the puzzle file with the given ID.
+	FetchID(id string) ([]byte, error)
+}
+
+// CatalogEntry describes a puzzle held by a Catalog. Date is zero for
+// puzzles whose date is unknown.
+type CatalogEntry struct {
+	ID   string
+	Name string
+	Date time.Time
+}
+
+// Patterns for dates in file names, such as "2021-07-31", "20210731" and
+// "wsj210731".
+var (
+	dashedDatePattern = regexp.MustCompile(`(\d{4})-(\d{2})-(\d{2})`)
+	longDatePattern   = regexp.MustCompile(`(\d{4})(\d{2})(\d{2})`)
+	shortDatePattern  = regexp.MustCompile(`(\d{2})(\d{2})(\d{2})`)
+)
+
+// dateFromName returns the date in a puzzle file name, if any.
+func dateFromName(name string) (time.Time, bool) {
+	for _, layout := range []struct {
+		pattern *regexp.Regexp
+		format  string
+	}{
+		{dashedDatePattern, "2006-01-02"},
+		{longDatePattern, "20060102"},
+		{shortDatePattern, "060102"},
+	} {
+		match := layout.pattern.FindString(name)
+		if match == "" {
+			continue
+		}
+		if date, err := time.Parse(layout.format, match); err == nil {
+			return date, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// dirFile is an indexed puzzle file.
+type dirFile struct {
+	CatalogEntry
+	path    string
+	modTime time.Time
+}
+
+// DirSource is a Source backed by a directory of puzzle files. Files are
+// indexed by the date in their name, or by name if they have none. It is
+// safe for concurrent use.
+type DirSource struct {
+	id   string
+	name string
+	dir  string
+
+	mu     sync.RWMutex
+	files  []dirFile
+	byID   map[string]*dirFile
+	byDate map[string]*dirFile
+}
+
+func NewDirSource(id, name, dir string) (*DirSource, error) {
+	s := &DirSource{id: id, name: name, dir: dir}
+	if err := s.Scan(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *DirSource) ID() string   { return s.id }
+func (s *DirSource) Name() string { return s.name }
+
+// Format is empty because files of any supported format may be mixed, so
+// the format is detected from each file's contents.
+func (s *DirSource) Format() string { return "" }
+
+func (s *DirSource) Publishes(date time.Time) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.byDate[date.Format("2006-01-02")]
+	return ok
+}
+
+func (s *DirSource) Fetch(date time.Time) ([]byte, error) {
+	s.mu.RLock()
+	file, ok := s.byDate[date.Format("2006-01-02")]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return ioutil.ReadFile(file.path)
+}
+
+func (s *DirSource) FetchID(id string) ([]byte, error) {
+	s.mu.RLock()
+	file, ok := s.byID[id]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return ioutil.ReadFile(file.path)
+}
+
+func (s *DirSource) Entries() []CatalogEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entries := make([]CatalogEntry, len(s.files))
+	for i, file := range s.files {
+		entries[i] = file.CatalogEntry
+	}
+	return entries
+}
+
+// Scan re-indexes the directory. Cached copies of changed and removed files
+// are dropped.
+func (s *DirSource) Scan() error {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	var files []dirFile
+	for _, info := range infos {
+		if info.IsDir() || !format.Supported(format.FromName(info.Name())) {
+			continue
+		}
+		name := strings.TrimSuffix(info.Name(), filepath.Ext(info.Name()))
+		date, _ := dateFromName(name)
+		files = append(files, dirFile{
+			CatalogEntry: CatalogEntry{Name: name, Date: date},
+			path:         filepath.Join(s.dir, info.Name()),
+			modTime:      info.ModTime(),
+		})
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		if !files[i].Date.Equal(files[j].Date) {
+			return files[i].Date.Before(files[j].Date)
+		}
+		return files[i].Name < files[j].Name
+	})
+
+	byID := make(map[string]*dirFile)
+	byDate := make(map[string]*dirFile)
+	for i := range files {
+		file := &files[i]
+		// The first file for a date gets the same ID a dated source would
+		// give it. Other files are identified by name.
+		key := file.Date.Format("2006-01-02")
+		if _, taken := byDate[key]; !file.Date.IsZero() && !taken {
+			file.ID = puzzleID(s.id, file.Date)
+			byDate[key] = file
+		} else {
+			file.ID = s.id + "-" + file.Name
+		}
+		byID[file.ID] = file
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, file := range files {
+		if old, ok := s.byID[file.ID]; !ok {
+			log.Printf("Found puzzle %v in %v.", file.ID, s.dir)
+		} else if !old.modTime.Equal(file.modTime) && GlobalPuzzleCache != nil {
+			// Drop the stale parsed copy so the new file is read.
+			GlobalPuzzleCache.Delete(file.ID)
+		}
+	}
+	for id := range s.byID {
+		if _, ok := byID[id]; !ok {
+			log.Printf("Puzzle %v was removed from %v.", id, s.dir)
+			if GlobalPuzzleCache != nil {
+				GlobalPuzzleCache.Delete(id)
+			}
+		}
+	}
+	s.files = files
+	s.byID = byID
+	s.byDate = byDate
+	return nil
+}
+
+// Watch re-indexes the directory every interval until done is closed, so
+// files added, changed or removed show up within an interval. It polls
+// rather than subscribing to file system events, which would need a
+// platform-specific dependency, so changes aren't seen until the next scan.
+func (s *DirSource) Watch(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Scan(); err != nil {
+				log.Printf("Error scanning %v: %v", s.dir, err)
+			}
+		case <-done:
+			return
+		}
+	}
+}
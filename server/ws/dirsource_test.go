@@ -0,0 +1,113 @@
+package ws
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// entryIDs returns the IDs a catalog lists, in order.
+func entryIDs(c Catalog) []string {
+	var ids []string
+	for _, entry := range c.Entries() {
+		ids = append(ids, entry.ID)
+	}
+	return ids
+}
+
+func equalIDs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// TestDirSource checks that a directory's puzzles are listed and fetched by
+// date or name, and that rescanning picks up added and removed files.
+func TestDirSource(t *testing.T) {
+	setupGlobals(t)
+	data, err := ioutil.ReadFile("../format/testdata/wsj.puz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	write := func(name string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("wsj210731.puz")
+	write("2021-07-31 copy.puz")
+	write("themeless.puz")
+	write("notes.md")
+
+	source, err := NewDirSource("dir", "Directory", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saturday := time.Date(2021, 7, 31, 0, 0, 0, 0, time.UTC)
+	// Undated files come first. Files for the same date are ordered by
+	// name, and all but the first are known by their names.
+	want := []string{"dir-themeless", puzzleID("dir", saturday), "dir-wsj210731"}
+	if got := entryIDs(source); !equalIDs(got, want) {
+		t.Errorf("entries are %q, want %q", got, want)
+	}
+	if !source.Publishes(saturday) || source.Publishes(saturday.AddDate(0, 0, 1)) {
+		t.Error("Publishes doesn't match the dated files")
+	}
+	if _, err := source.Fetch(saturday); err != nil {
+		t.Error(err)
+	}
+	if _, err := source.FetchID("dir-themeless"); err != nil {
+		t.Error(err)
+	}
+
+	write("wsj210807.puz")
+	if err := os.Remove(filepath.Join(dir, "themeless.puz")); err != nil {
+		t.Fatal(err)
+	}
+	if err := source.Scan(); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{puzzleID("dir", saturday), "dir-wsj210731", puzzleID("dir", saturday.AddDate(0, 0, 7))}
+	if got := entryIDs(source); !equalIDs(got, want) {
+		t.Errorf("after rescanning, entries are %q, want %q", got, want)
+	}
+	if _, err := source.FetchID("dir-themeless"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("removed file: got %v, want %v", err, ErrNotFound)
+	}
+}
+
+// TestDirSourceWatch checks that a watched directory notices a new file.
+func TestDirSourceWatch(t *testing.T) {
+	dir := t.TempDir()
+	source, err := NewDirSource("watched", "Watched", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go source.Watch(10*time.Millisecond, done)
+
+	data, err := ioutil.ReadFile("../format/testdata/wsj.puz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "new.puz"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; len(source.Entries()) == 0; i++ {
+		if i == 500 {
+			t.Fatal("new file was not noticed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
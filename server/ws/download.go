@@ -0,0 +1,103 @@
+package ws
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/tmngo/crossword-server/format"
+)
+
+// progress returns the room's fill, markings and elapsed time.
+func (r *Room) progress() *format.Progress {
+	return &format.Progress{
+		State: r.state,
+		Flags: r.flags,
+		Timer: r.ltimTimer(),
+	}
+}
+
+// exportTypes are the content types of the formats puzzles can be exported
+// in.
+var exportTypes = map[string]string{
+	format.Puz:  "application/x-crossword",
+	format.Ipuz: "application/json",
+	format.Text: "text/plain; charset=utf-8",
+	format.Xd:   "text/plain; charset=utf-8",
+}
+
+// ServePuz serves the puzzle of the room at /puz/<room> as a .puz file,
+// including the room's current fill. With ?format=ipuz, txt or xd, the
+// puzzle is exported in that format instead, without the fill. A .puz file
+// may be scrambled with a four-digit ?key=, as Across Lite does. A puzzle
+// without a published solution can only be exported as ipuz, since the other
+// formats would need an answer key.
+func ServePuz(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	fileFormat := r.URL.Query().Get("format")
+	if fileFormat == "" {
+		fileFormat = format.Puz
+	}
+	contentType, ok := exportTypes[fileFormat]
+	if !ok {
+		http.Error(w, "Unsupported format", http.StatusBadRequest)
+		return
+	}
+	key := 0
+	if k := r.URL.Query().Get("key"); k != "" {
+		var err error
+		key, err = strconv.Atoi(k)
+		if err != nil || fileFormat != format.Puz || !format.ValidKey(key) {
+			http.Error(w, "Invalid key", http.StatusBadRequest)
+			return
+		}
+	}
+	roomName := "/ws/" + strings.TrimPrefix(r.URL.Path, "/puz/")
+	room := hub.Room(roomName)
+	if room == nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	var puzzle Puzzle
+	var data []byte
+	err := room.call(func(room *Room) error {
+		if room.puzzle.Grid == "" {
+			return ErrNotFound
+		}
+		puzzle = room.puzzle
+		if key != 0 {
+			if err := format.Lock(&puzzle, key); err != nil {
+				return err
+			}
+		}
+		var err error
+		if fileFormat == format.Puz {
+			data, err = format.WritePuz(puzzle, room.progress())
+		} else {
+			data, err = format.Write(puzzle, fileFormat)
+		}
+		return err
+	})
+	if err == ErrNotFound || err == ErrRoomClosed {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	} else if err == format.ErrPuzScramble {
+		http.Error(w, "Puzzle cannot be scrambled", http.StatusBadRequest)
+		return
+	} else if err == format.ErrNoSolution {
+		http.Error(w, "Puzzle has no solution; export it as ipuz", http.StatusConflict)
+		return
+	} else if err != nil {
+		log.Printf("Error writing %v: %v", roomName, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", puzzle.ID+"."+fileFormat))
+	w.Write(data)
+}
@@ -0,0 +1,173 @@
+package ws
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/tmngo/crossword-server/format"
+)
+
+// TestServePuzFormats checks that a room's puzzle can be downloaded in each
+// export format.
+func TestServePuzFormats(t *testing.T) {
+	server, puzzle := testServer(t)
+	conn, err := dial(server, "export", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := readRegister(conn); err != nil {
+		t.Fatal(err)
+	}
+	drain(conn)
+	if err := conn.WriteJSON(message(TagPuzzleLoad, PuzzleLoad{ID: puzzle.ID})); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fileFormat := range []string{"", format.Puz, format.Ipuz, format.Text, format.Xd} {
+		var resp *http.Response
+		// The puzzle loads asynchronously.
+		for i := 0; i < 100; i++ {
+			resp, err = http.Get(server.URL + "/puz/export?format=" + fileFormat)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != http.StatusNotFound {
+				break
+			}
+			resp.Body.Close()
+			time.Sleep(10 * time.Millisecond)
+		}
+		data, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("format %q: status %v: %s", fileFormat, resp.StatusCode, data)
+			continue
+		}
+		got, err := format.Parse(data, puzzle.ID, "")
+		if err != nil {
+			t.Errorf("format %q: %v", fileFormat, err)
+			continue
+		}
+		if got.Grid != puzzle.Grid {
+			t.Errorf("format %q: grid is %q, want %q", fileFormat, got.Grid, puzzle.Grid)
+		}
+	}
+
+	resp, err := http.Get(server.URL + "/puz/export?format=jpz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("format jpz: status %v, want %v", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestServePuzKey(t *testing.T) {
+	server, puzzle := testServer(t)
+	conn, err := dial(server, "locked", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := readRegister(conn); err != nil {
+		t.Fatal(err)
+	}
+	drain(conn)
+	if err := conn.WriteJSON(message(TagPuzzleLoad, PuzzleLoad{ID: puzzle.ID})); err != nil {
+		t.Fatal(err)
+	}
+
+	var resp *http.Response
+	// The puzzle loads asynchronously.
+	for i := 0; i < 100; i++ {
+		resp, err = http.Get(server.URL + "/puz/locked?key=7309")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusNotFound {
+			break
+		}
+		resp.Body.Close()
+		time.Sleep(10 * time.Millisecond)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %v: %s", resp.StatusCode, data)
+	}
+	got, err := format.Parse(data, puzzle.ID, format.Puz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Scrambled {
+		t.Fatal("exported puzzle is not scrambled")
+	}
+	if err := format.Unlock(&got, "7309"); err != nil {
+		t.Fatal(err)
+	}
+	if got.Scrambled || got.Grid != puzzle.Grid {
+		t.Errorf("unlocked grid is %q, want %q", got.Grid, puzzle.Grid)
+	}
+
+	for _, query := range []string{"key=12", "key=abcd", "key=1234&format=ipuz"} {
+		resp, err := http.Get(server.URL + "/puz/locked?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: status %v, want %v", query, resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+}
+
+// TestServePuzNoSolution checks that a puzzle without a solution is only
+// exported as ipuz, rather than with a made-up answer key.
+func TestServePuzNoSolution(t *testing.T) {
+	server, hub, _ := testHubServer(t, nil)
+	conn, err := dial(server, "unsolved", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := readRegister(conn); err != nil {
+		t.Fatal(err)
+	}
+	puzzle := testPuzzle("unsolved", "????", 2, 2)
+	puzzle.NoSolution = true
+	hub.Room("/ws/unsolved").call(func(room *Room) error {
+		room.setPuzzle(puzzle)
+		return nil
+	})
+
+	tests := []struct {
+		query  string
+		status int
+	}{
+		{"", http.StatusConflict},
+		{"?format=txt", http.StatusConflict},
+		{"?format=xd", http.StatusConflict},
+		{"?key=1234", http.StatusBadRequest},
+		{"?format=ipuz", http.StatusOK},
+	}
+	for _, test := range tests {
+		resp, err := http.Get(server.URL + "/puz/unsolved" + test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.status {
+			t.Errorf("%q: status %v, want %v", test.query, resp.StatusCode, test.status)
+		}
+	}
+}
@@ -6,178 +6,478 @@ package ws
 
 import (
 	"encoding/json"
-	"fmt"
+	"errors"
 	"log"
 	"math"
 	"math/rand"
+	"time"
 )
 
 type Subscription struct {
 	client *Client
 	room   string
+	// actor is the room the client joined. assigned is closed once the hub
+	// has set it.
+	actor    *Room
+	assigned chan struct{}
 }
 
-// Hub maintains the set of active clients and broadcasts messages to the
-// clients.
+// Hub maintains the set of active clients and rooms. Its maps are only used
+// from the Run goroutine; each room's state is owned by the room's own
+// goroutine. The hub never waits on a room: clients send their own join and
+// leave commands, and the hub only assigns rooms and closes empty ones.
 type Hub struct {
 	// Set of registered clients.
 	clients map[*Client]bool
 
-	send chan ClientMessage
-
-	// Outbound messages to the clients.
-	broadcast chan RoomMessage
-
 	// Register requests from the clients.
-	register chan Subscription
+	register chan *Subscription
 
 	// Unregister requests from clients.
 	unregister chan Subscription
 
+	// Requests to look up a room by name.
+	lookup chan roomLookup
+
 	rooms map[string]*Room
+
+	// Rooms that were closed and are still saving, by name. A room reopened
+	// under the same name waits for the old one before restoring.
+	closing map[string]*Room
+
+	// stopped receives rooms whose goroutines have exited.
+	stopped chan *Room
+
+	// shutdown receives a channel to close once every room is saved.
+	shutdown chan chan struct{}
+
+	// store saves the hub's rooms, or is nil to keep them in memory.
+	store *RoomStore
+}
+
+type roomLookup struct {
+	name  string
+	reply chan *Room
 }
 
+// Room is an actor: its fields are only used from the goroutine running
+// run, and other goroutines send it commands with do or call.
 type Room struct {
+	name string
+	// commands are run in order by the room's goroutine.
+	commands chan func(*Room)
 	// Registered clients in the room
 	clients map[*Client]bool
-	puzzle  string
-	state   []byte
+	puzzle  Puzzle
+	// Entries by cell index. Rebus cells hold more than one letter.
+	state []string
+	// Per-cell markings such as revealed or previously incorrect cells.
+	flags []CellFlags
+	// IDs of the players who filled in each cell.
+	authors []string
+	// enteredAt holds when each cell was last entered, and is zero for
+	// cells no one has entered.
+	enteredAt []time.Time
+	stats     map[string]*PlayerStats
+	// completedWords marks the words, by clue position in numbering order,
+	// that have been credited as completed, so each is credited once.
+	completedWords []bool
+	// edits holds recent cell edits for undo, and undone the edits that
+	// were undone since, for redo. Neither is kept across puzzles.
+	edits  []cellEdit
+	undone []cellEdit
+	timer  roomTimer
+	// lastActivity is when a player last moved or typed.
+	lastActivity time.Time
+	// solved is set once the grid is filled in correctly, which locks it
+	// and stops the timer.
+	solved bool
+	// filled is set while every cell has an entry.
+	filled  bool
 	height  int
 	width   int
 	players map[string]*Player
+	// Players who have left, kept so their positions survive restarts.
+	departed map[string]*Player
+	// Recent chat messages, oldest first.
+	chat []string
+	// saved is the last snapshot written to the store, for skipping
+	// unchanged rooms.
+	saved []byte
+	// Saved work on puzzles the room has switched away from, by puzzle ID.
+	history map[string]*puzzleProgress
+	// seq numbers the room's changes for clients that receive deltas.
+	seq          uint64
+	deltaClients map[*Client]bool
+	// Changes made since the last flush.
+	changedCells   map[int]bool
+	changedPlayers map[string]bool
+	settings       RoomSettings
+	// Resumable sessions by the hash of their token.
+	sessions map[string]*session
+	// Recent broadcasts, for replay to reconnecting clients. eventPos is
+	// the position of the last one.
+	events   []roomEvent
+	eventPos uint64
+	// store saves the room, or is nil if rooms are kept in memory.
+	store *RoomStore
+
+	// members counts the clients the hub has assigned to the room, and is
+	// only used by the hub's goroutine.
+	members int
+	// quit is closed by the hub to stop the room, and done is closed once
+	// the room has saved itself and stopped. previous is the done channel
+	// of an earlier room with the same name, if it was still saving when
+	// this one opened. stopped, if set, is sent the room when it stops.
+	quit     chan struct{}
+	done     chan struct{}
+	previous <-chan struct{}
+	stopped  chan<- *Room
 }
 
+// ErrRoomClosed is returned by commands sent to a room that has stopped.
+var ErrRoomClosed = errors.New("Room is closed.")
+
 var GlobalHub *Hub
-var GlobalPuzzleCache map[string]Puzzle
 
+// NewHub returns a hub whose rooms are saved in GlobalRoomStore.
 func NewHub() *Hub {
 	return &Hub{
-		send:       make(chan ClientMessage),
-		broadcast:  make(chan RoomMessage),
-		register:   make(chan Subscription),
+		register:   make(chan *Subscription),
 		unregister: make(chan Subscription),
+		lookup:     make(chan roomLookup),
 		clients:    make(map[*Client]bool),
 		rooms:      make(map[string]*Room),
+		closing:    make(map[string]*Room),
+		stopped:    make(chan *Room),
+		shutdown:   make(chan chan struct{}),
+		store:      GlobalRoomStore,
+	}
+}
+
+// newRoom returns an empty room. The caller starts its goroutine.
+func newRoom(name string) *Room {
+	r := &Room{
+		name:     name,
+		commands: make(chan func(*Room), 64),
+		clients:  make(map[*Client]bool),
+		state:    make([]string, 0),
+		players:  make(map[string]*Player),
+		departed: make(map[string]*Player),
+
+		deltaClients: make(map[*Client]bool),
+		sessions:     make(map[string]*session),
+
+		quit: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	return r
+}
+
+// run restores the room, then executes its commands, periodically saves the
+// room and resends its timer, and pauses the timer when the room goes idle.
+// It returns once the room is stopped.
+func (r *Room) run() {
+	// Restoring may fetch the puzzle, so it runs here rather than blocking
+	// the hub.
+	r.restore()
+	ticker := time.NewTicker(persistInterval)
+	defer ticker.Stop()
+	idle := time.NewTicker(idleCheckInterval)
+	defer idle.Stop()
+	for {
+		select {
+		case command := <-r.commands:
+			command(r)
+		case <-ticker.C:
+			r.persist()
+			r.syncTimer()
+		case <-idle.C:
+			r.checkIdle()
+		case <-r.quit:
+			r.stop()
+			return
+		}
+	}
+}
+
+// stop runs the commands already queued, such as the last client leaving,
+// then pauses the timer and saves the room.
+func (r *Room) stop() {
+	for len(r.commands) > 0 {
+		command := <-r.commands
+		command(r)
+	}
+	r.pauseTimer(r.lastActivity, PausedIdle)
+	r.persist()
+	close(r.done)
+	if r.stopped != nil {
+		r.stopped <- r
+	}
+}
+
+// do runs command on the room's goroutine without waiting for it. Commands
+// sent to a stopped room are dropped.
+func (r *Room) do(command func(*Room)) {
+	select {
+	case r.commands <- command:
+	case <-r.done:
+	}
+}
+
+// call runs command on the room's goroutine and returns its error, or
+// ErrRoomClosed if the room stopped first.
+func (r *Room) call(command func(*Room) error) error {
+	reply := make(chan error, 1)
+	select {
+	case r.commands <- func(r *Room) {
+		reply <- command(r)
+	}:
+	case <-r.done:
+		return ErrRoomClosed
+	}
+	select {
+	case err := <-reply:
+		return err
+	case <-r.done:
+		// The room may have run the command while stopping.
+		select {
+		case err := <-reply:
+			return err
+		default:
+			return ErrRoomClosed
+		}
 	}
 }
 
+// Room returns the room with name, or nil if it is not open.
+func (h *Hub) Room(name string) *Room {
+	reply := make(chan *Room)
+	h.lookup <- roomLookup{name, reply}
+	return <-reply
+}
+
 func (h *Hub) Run() {
 	for {
 		select {
 		// Register new clients.
 		case subscription := <-h.register:
 			log.Println("Registering client.")
-			client := subscription.client
 			roomName := subscription.room
-			h.clients[client] = true
-			player := Player{
-				Name:  "player01",
-				ID:    client.id,
-				Color: randomColor(0.6),
-				// Color:    Color{0.1, 0.9, 0.4, 1.0},
-				Position: Position{0, 0, Across},
-			}
-			if _, ok := h.rooms[roomName]; ok {
-				// Room exists.
-				log.Printf("Room %v exists.", subscription.room)
-				player.Name = fmt.Sprintf("player%02d", len(h.rooms[roomName].players))
-				h.rooms[roomName].clients[client] = true
-				h.rooms[roomName].players[client.id] = &player
-			} else {
-				// Create new room.
-				log.Printf("Creating room %v.", subscription.room)
-				room := Room{
-					map[*Client]bool{client: true},
-					"",
-					make([]byte, 0),
-					0,
-					0,
-					map[string]*Player{client.id: &player},
+			h.clients[subscription.client] = true
+			room, ok := h.rooms[roomName]
+			if !ok {
+				log.Printf("Opening room %v.", roomName)
+				room = newRoom(roomName)
+				room.store = h.store
+				room.stopped = h.stopped
+				if old, ok := h.closing[roomName]; ok {
+					room.previous = old.done
+					delete(h.closing, roomName)
 				}
-				h.rooms[subscription.room] = &room
+				h.rooms[roomName] = room
+				go room.run()
 			}
-			log.Print(client.id)
-			message, _ := json.Marshal(TaggedMessage{
-				Tag:  TagRegister,
-				Data: Register{client.id},
-			})
-			client.send <- message
-			room := h.rooms[subscription.room]
-			message, _ = json.Marshal(TaggedMessage{
-				Tag: TagPlayerUpdate,
-				Data: PlayerUpdate{
-					"",
-					h.rooms[subscription.room].players,
-				},
-			})
-			h.broadcastMessage(room, "", message)
+			room.members++
+			subscription.actor = room
+			close(subscription.assigned)
 		case subscription := <-h.unregister:
 			log.Println("Unregistering client.")
 			client := subscription.client
 			if _, ok := h.clients[client]; ok {
 				delete(h.clients, client)
-				if _, ok := h.rooms[subscription.room].clients[client]; ok {
-					delete(h.rooms[subscription.room].clients, client)
-					delete(h.rooms[subscription.room].players, client.id)
-				}
-				close(client.send)
-				room := h.rooms[subscription.room]
-				taggedMessage := TaggedMessage{
-					Tag: TagPlayerUpdate,
-					Data: PlayerUpdate{
-						string(room.state),
-						room.players,
-					},
-				}
-				output, err := json.Marshal(taggedMessage)
-				if err != nil {
-					return
+				room := subscription.actor
+				room.members--
+				// Rooms kept in memory stay open, since closing them would
+				// lose them. A room closed by a shutdown is already gone.
+				if room.members == 0 && h.store != nil && h.rooms[room.name] == room {
+					h.closeRoom(room)
 				}
-				client.hub.broadcastMessage(room, "", output)
 			}
-		case clientMessage := <-h.send:
-			client := clientMessage.client
-			select {
-			case client.send <- clientMessage.message:
-			default:
-				log.Print("Default send.")
-				close(client.send)
-				delete(h.clients, client)
+		case room := <-h.stopped:
+			if h.closing[room.name] == room {
+				delete(h.closing, room.name)
+			}
+		case lookup := <-h.lookup:
+			lookup.reply <- h.rooms[lookup.name]
+		case done := <-h.shutdown:
+			for _, room := range h.rooms {
+				h.closeRoom(room)
+			}
+			var saving []<-chan struct{}
+			for _, room := range h.closing {
+				saving = append(saving, room.done)
+			}
+			go func() {
+				for _, done := range saving {
+					<-done
+				}
+				close(done)
+			}()
+		}
+	}
+}
+
+// closeRoom stops a room, which saves itself on the way out.
+func (h *Hub) closeRoom(room *Room) {
+	log.Printf("Closing room %v.", room.name)
+	delete(h.rooms, room.name)
+	h.closing[room.name] = room
+	close(room.quit)
+}
+
+// Shutdown closes every open room and returns once they have all been saved.
+// It is meant for the server's exit, so a client that joins afterwards gets a
+// new room that isn't saved unless it empties.
+func (h *Hub) Shutdown() {
+	done := make(chan struct{})
+	h.shutdown <- done
+	<-done
+}
+
+// join adds a client to the room as a new player and sends it the room's
+// state.
+func (r *Room) join(client *Client) {
+	r.expireSessions()
+	if player, session := r.resume(client.resume.token); player != nil {
+		r.rejoin(client, player, session)
+		return
+	}
+	player := Player{
+		Name:  r.defaultName(client.id),
+		ID:    client.id,
+		Color: randomColor(0.6),
+		// Color:    Color{0.1, 0.9, 0.4, 1.0},
+		Position: Position{0, 0, Across},
+	}
+	r.clients[client] = true
+	r.players[client.id] = &player
+	r.claimHost(client.id)
+	log.Print(client.id)
+	r.send(client, TagRegister, Register{client.id, r.newSession(client.id)})
+	r.sendRoomState(client)
+	r.markPlayer(client.id)
+	r.flush()
+}
+
+// rejoin resumes a player's session on a new connection and replays what
+// the player missed.
+func (r *Room) rejoin(client *Client, player *Player, session *session) {
+	log.Printf("Resuming player %v.", player.ID)
+	// Drop the player's old connection, if it is still open.
+	for other := range r.clients {
+		if other.id == player.ID {
+			delete(r.clients, other)
+			delete(r.deltaClients, other)
+			other.close()
+		}
+	}
+	if session.LeftAt.IsZero() {
+		session.LeftPos = r.eventPos
+	}
+	session.LeftAt = time.Time{}
+	delete(r.departed, player.ID)
+	client.id = player.ID
+	r.clients[client] = true
+	r.players[player.ID] = player
+	r.claimHost(player.ID)
+	r.send(client, TagRegister, Register{player.ID, client.resume.token})
+	if r.puzzle.Grid != "" {
+		r.send(client, TagPuzzle, r.puzzle)
+		r.send(client, TagTimer, r.timerState())
+	}
+	r.send(client, TagSettings, r.settings)
+	r.replay(client, session, client.resume)
+	r.markPlayer(player.ID)
+	r.flush()
+}
+
+// leave removes a client from the room. Its player is kept as departed.
+func (r *Room) leave(client *Client) {
+	delete(r.clients, client)
+	delete(r.deltaClients, client)
+	client.close()
+	for other := range r.clients {
+		if other.id == client.id {
+			// The player has already resumed on a new connection.
+			return
+		}
+	}
+	if player, ok := r.players[client.id]; ok {
+		r.endSession(client.id)
+		r.departed[client.id] = player
+		delete(r.players, client.id)
+		r.markPlayer(client.id)
+		if r.settings.Host == client.id {
+			r.settings.Host = ""
+			for id := range r.players {
+				r.claimHost(id)
+				break
 			}
-		case data := <-h.broadcast:
-			room := h.rooms[data.room]
-			h.broadcastMessage(room, "", data.message)
+			r.broadcast(TagSettings, r.settings)
 		}
 	}
+	if len(r.clients) == 0 {
+		r.persist()
+	}
+	r.flush()
+}
+
+// sendRoomState sends a joining client the room's puzzle and chat history.
+func (r *Room) sendRoomState(client *Client) {
+	for _, text := range r.chat {
+		r.send(client, TagText, text)
+	}
+	if r.puzzle.Grid != "" {
+		r.send(client, TagPuzzle, r.puzzle)
+		r.send(client, TagTimer, r.timerState())
+	}
+	r.send(client, TagSettings, r.settings)
+}
+
+// claimHost makes a player the host if the host isn't in the room.
+func (r *Room) claimHost(id string) {
+	if _, ok := r.players[r.settings.Host]; !ok {
+		r.settings.Host = id
+	}
 }
 
 func randomColor(lightness float64) Color {
 	r := 0.05 + 0.9*rand.Float64()
 	g := 0.05 + 0.9*rand.Float64()
 	b := 0.05 + 0.9*rand.Float64()
-	rlin := math.Pow((r+0.055)/1.055, 2.4)
-	glin := math.Pow((g+0.055)/1.055, 2.4)
-	blin := math.Pow((b+0.055)/1.055, 2.4)
-	// rlin := r
-	// glin := g
-	// blin := g
+	return withLightness(Color{r, g, b, 1.0}, lightness)
+	// color = hsl(rand.Float64(), 0.9, 0.5)
+}
+
+// perceivedLightness returns the CIE lightness L* of a color, scaled to run
+// from 0 for black to 1 for white. Below the CIE threshold, L* is linear in
+// luminance with a slope of 903.3 on its usual 0-100 scale, which is 9.033 on
+// this one; the two pieces meet at the threshold.
+func perceivedLightness(c Color) float64 {
+	rlin := math.Pow((c.R+0.055)/1.055, 2.4)
+	glin := math.Pow((c.G+0.055)/1.055, 2.4)
+	blin := math.Pow((c.B+0.055)/1.055, 2.4)
 	luminance := 0.2126*rlin + 0.7152*glin + 0.0722*blin
-	perceivedLightness := luminance * 903.3
 	if luminance > 0.008856 {
-		perceivedLightness = 1.16*math.Pow(luminance, 0.333) - 0.16
+		return 1.16*math.Pow(luminance, 0.333) - 0.16
 	}
-	scale := lightness / perceivedLightness
-	color := Color{
-		r * scale,
-		g * scale,
-		b * scale,
-		1.0,
+	return luminance * 9.033
+}
+
+// withLightness scales a color to the given perceived lightness.
+func withLightness(c Color, lightness float64) Color {
+	perceived := perceivedLightness(c)
+	if perceived <= 0 {
+		return Color{lightness, lightness, lightness, c.A}
+	}
+	scale := lightness / perceived
+	return Color{
+		c.R * scale,
+		c.G * scale,
+		c.B * scale,
+		c.A,
 	}
-	return color
-	// color = hsl(rand.Float64(), 0.9, 0.5)
-	// log.Printf("Luminance: %v", luminance)
-	// log.Printf("Perceived: %v", perceivedLightness)
-	// log.Print(color)
 }
 
 func hsl(h, s, l float64) Color {
@@ -213,19 +513,47 @@ func hue2rgb(p, q, h float64) float64 {
 	return p
 }
 
-func (h *Hub) broadcastMessage(room *Room, excludedClient string, message []byte) {
-	log.Printf("Broadcasting to all %v clients.\n", len(room.clients))
-	for client := range room.clients {
-		if client.id == excludedClient {
-			continue
-		}
-		select {
-		case client.send <- message:
-		default:
-			// The default case is run if no other case is ready.
-			log.Print("Default broadcast.")
-			close(client.send)
-			delete(h.clients, client)
-		}
+// send sends a message to one client in the room.
+func (r *Room) send(client *Client, tag MessageTag, data interface{}) error {
+	message, err := json.Marshal(TaggedMessage{tag, data})
+	if err != nil {
+		return err
+	}
+	r.sendMessage(client, message)
+	return nil
+}
+
+// broadcast sends a message to every client in the room.
+func (r *Room) broadcast(tag MessageTag, data interface{}) error {
+	message, err := json.Marshal(TaggedMessage{tag, data})
+	if err != nil {
+		return err
+	}
+	r.broadcastMessage(message)
+	return nil
+}
+
+func (r *Room) broadcastMessage(message []byte) {
+	log.Printf("Broadcasting to all %v clients.\n", len(r.clients))
+	for client := range r.clients {
+		r.sendMessage(client, message)
+	}
+}
+
+// sendMessage queues a message for a client, dropping the client if its
+// buffer is full. Clients no longer in the room are skipped, since their
+// send channels may be closed.
+func (r *Room) sendMessage(client *Client, message []byte) {
+	if !r.clients[client] {
+		return
+	}
+	select {
+	case client.send <- message:
+	default:
+		// The default case is run if no other case is ready.
+		log.Print("Default broadcast.")
+		delete(r.clients, client)
+		delete(r.deltaClients, client)
+		client.close()
 	}
 }
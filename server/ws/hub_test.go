@@ -0,0 +1,517 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/tmngo/crossword-server/format"
+)
+
+var (
+	setupOnce sync.Once
+	wsjPuzzle Puzzle
+	setupErr  error
+)
+
+// setupGlobals sets up an empty source registry and a puzzle cache holding
+// wsj.puz. Rooms from earlier tests may still be running, so the globals are
+// only set once.
+func setupGlobals(t *testing.T) Puzzle {
+	t.Helper()
+	log.SetOutput(ioutil.Discard)
+	setupOnce.Do(func() {
+		var cache *PuzzleCache
+		if cache, setupErr = NewPuzzleCache("", 0); setupErr != nil {
+			return
+		}
+		GlobalPuzzleCache = cache
+		GlobalSources = NewSourceRegistry()
+		var data []byte
+		if data, setupErr = ioutil.ReadFile("../format/testdata/wsj.puz"); setupErr != nil {
+			return
+		}
+		if wsjPuzzle, setupErr = format.Parse(data, "wsj-2021-7-31", ""); setupErr != nil {
+			return
+		}
+		setupErr = cache.Put(wsjPuzzle.ID, "puz", data, wsjPuzzle)
+	})
+	if setupErr != nil {
+		t.Fatal(setupErr)
+	}
+	return wsjPuzzle
+}
+
+// testServer serves rooms over websockets with wsj.puz in the puzzle cache.
+func testServer(t *testing.T) (*httptest.Server, Puzzle) {
+	t.Helper()
+	server, _, puzzle := testHubServer(t, nil)
+	return server, puzzle
+}
+
+// testHubServer is like testServer, but also returns the hub, which saves
+// its rooms in store if it is not nil.
+func testHubServer(t *testing.T, store *RoomStore) (*httptest.Server, *Hub, Puzzle) {
+	t.Helper()
+	puzzle := setupGlobals(t)
+	hub := NewHub()
+	hub.store = store
+	go hub.Run()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws/", func(w http.ResponseWriter, r *http.Request) {
+		ServeWs(hub, w, r)
+	})
+	mux.HandleFunc("/puz/", func(w http.ResponseWriter, r *http.Request) {
+		ServePuz(hub, w, r)
+	})
+	mux.HandleFunc("/summary/", func(w http.ResponseWriter, r *http.Request) {
+		ServeSummary(hub, w, r)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server, hub, puzzle
+}
+
+// dial connects to a room, with query appended to the URL if it is set.
+func dial(server *httptest.Server, room, query string) (*websocket.Conn, error) {
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/" + room
+	if query != "" {
+		url += "?" + query
+	}
+	header := http.Header{"Origin": {"http://localhost:3000"}}
+	conn, _, err := websocket.DefaultDialer.Dial(url, header)
+	return conn, err
+}
+
+// readTag reads messages until one with tag arrives, and decodes its data
+// into v.
+func readTag(conn *websocket.Conn, tag MessageTag, v interface{}) error {
+	for {
+		var msg Message
+		if err := conn.ReadJSON(&msg); err != nil {
+			return err
+		}
+		if msg.Tag == tag {
+			return json.Unmarshal(msg.Data, v)
+		}
+	}
+}
+
+// readRegister reads messages until the room registers the connection.
+func readRegister(conn *websocket.Conn) (Register, error) {
+	var register Register
+	err := readTag(conn, TagRegister, &register)
+	return register, err
+}
+
+// waitClosed waits for the hub to close a room.
+func waitClosed(t *testing.T, hub *Hub, name string) {
+	t.Helper()
+	for i := 0; hub.Room(name) != nil; i++ {
+		if i == 500 {
+			t.Fatalf("room %v was not closed", name)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// drain discards a connection's messages until it closes.
+func drain(conn *websocket.Conn) {
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+func message(tag MessageTag, data interface{}) map[string]interface{} {
+	return map[string]interface{}{"tag": tag, "data": data}
+}
+
+// TestStress runs many clients against one room, with some resuming their
+// sessions while their old connections are still sending. Once everyone has
+// left, the room must be closed and saved with one player per client. Run it
+// with -race.
+func TestStress(t *testing.T) {
+	store, err := NewRoomStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, hub, puzzle := testHubServer(t, store)
+	const clients = 12
+
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conn, err := dial(server, "stress", "")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer conn.Close()
+			register, err := readRegister(conn)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			drain(conn)
+			conn.WriteJSON(message(TagSnapshot, SyncRequest{Deltas: i%2 == 0}))
+			conn.WriteJSON(message(TagPuzzleLoad, PuzzleLoad{ID: puzzle.ID}))
+			for j := 0; j < 150; j++ {
+				var msg map[string]interface{}
+				switch j % 8 {
+				case 0:
+					msg = message(TagPlayerAction, "a")
+				case 1:
+					msg = message(TagPlayerClick, Position{j % 15, i % 15, Across})
+				case 2:
+					msg = message(TagText, fmt.Sprint("hi ", j))
+				case 3:
+					msg = message(TagPlayerAction, KeyBackspace)
+				case 4:
+					msg = message(TagSummary, nil)
+				case 5:
+					msg = message(TagUndo, UndoRequest{ActionUndo, ScopeRoom})
+				case 6:
+					msg = message(TagCheck, CheckRequest{ActionCheck, ScopeWord})
+				case 7:
+					msg = message(TagSnapshot, SyncRequest{Deltas: true})
+				}
+				// The old connection is dropped once it resumes, so its
+				// writes may start failing.
+				conn.WriteJSON(msg)
+				if j%50 == 0 {
+					if resp, err := http.Get(server.URL + "/puz/stress"); err == nil {
+						resp.Body.Close()
+					}
+				}
+				if i%3 == 0 && j == 75 {
+					// Resume on a new connection while the old one keeps
+					// sending.
+					resumed, err := dial(server, "stress", "token="+register.Token+"&seq=0")
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					defer resumed.Close()
+					drain(resumed)
+					resumed.WriteJSON(message(TagPlayerAction, "b"))
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	waitClosed(t, hub, "/ws/stress")
+	saved, err := store.Load("/ws/stress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.PuzzleID != puzzle.ID {
+		t.Errorf("saved puzzle is %q, want %q", saved.PuzzleID, puzzle.ID)
+	}
+	// Resumed clients keep their players.
+	if len(saved.Players) != clients || len(saved.Sessions) != clients {
+		t.Errorf("saved %d players and %d sessions, want %d", len(saved.Players), len(saved.Sessions), clients)
+	}
+	for i, entry := range saved.State {
+		if entry != "" && entry != "A" && entry != "B" {
+			t.Errorf("cell %d holds %q, which no one typed", i, entry)
+		}
+	}
+	// Messages still in flight when a connection is dropped may be lost, so
+	// only the cap on the history is certain.
+	if len(saved.Chat) == 0 || len(saved.Chat) > maxChatHistory {
+		t.Errorf("saved %d chat messages, want at most %d", len(saved.Chat), maxChatHistory)
+	}
+}
+
+// TestHubNotBlockedByRoom checks that a room with a full command queue
+// doesn't hold up clients joining other rooms, or the hub closing it.
+func TestHubNotBlockedByRoom(t *testing.T) {
+	server, hub, _ := testHubServer(t, nil)
+	conn, err := dial(server, "busy", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := readRegister(conn); err != nil {
+		t.Fatal(err)
+	}
+	drain(conn)
+
+	room := hub.Room("/ws/busy")
+	release := make(chan struct{})
+	defer close(release)
+	room.do(func(*Room) {
+		<-release
+	})
+	go func() {
+		for i := 0; i < 2*cap(room.commands); i++ {
+			room.do(func(*Room) {})
+		}
+	}()
+	for len(room.commands) < cap(room.commands) {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Joining the busy room waits for it, but mustn't hold up the hub.
+	go func() {
+		if conn, err := dial(server, "busy", ""); err == nil {
+			defer conn.Close()
+			readRegister(conn)
+		}
+	}()
+	joined := make(chan error, 1)
+	go func() {
+		conn, err := dial(server, "other", "")
+		if err == nil {
+			defer conn.Close()
+			_, err = readRegister(conn)
+		}
+		joined <- err
+	}()
+	select {
+	case err := <-joined:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("joining another room was blocked by the busy room")
+	}
+}
+
+// TestRoomReopen checks that a room is saved and closed when its last client
+// leaves, and that reopening it straight away keeps the work done in it.
+func TestRoomReopen(t *testing.T) {
+	store, err := NewRoomStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, hub, puzzle := testHubServer(t, store)
+	const word = "CROSS"
+	for i := range word {
+		conn, err := dial(server, "reopen", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readRegister(conn); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			conn.WriteJSON(message(TagPuzzleLoad, PuzzleLoad{ID: puzzle.ID}))
+			var loaded Puzzle
+			if err := readTag(conn, TagPuzzle, &loaded); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var snapshot Snapshot
+		conn.WriteJSON(message(TagSnapshot, SyncRequest{}))
+		if err := readTag(conn, TagSnapshot, &snapshot); err != nil {
+			t.Fatal(err)
+		}
+		// Each visit sees the letters typed on earlier visits.
+		if got := snapshot.State[:i]; got != word[:i] {
+			t.Errorf("visit %d: row starts %q, want %q", i, got, word[:i])
+		}
+		conn.WriteJSON(message(TagPlayerClick, Position{0, i, Across}))
+		conn.WriteJSON(message(TagPlayerAction, strings.ToLower(word[i:i+1])))
+		conn.WriteJSON(message(TagSnapshot, SyncRequest{}))
+		if err := readTag(conn, TagSnapshot, &snapshot); err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+	}
+	waitClosed(t, hub, "/ws/reopen")
+	saved, err := store.Load("/ws/reopen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(saved.State[:len(word)], ""); got != word {
+		t.Errorf("saved row starts %q, want %q", got, word)
+	}
+}
+
+// TestSlowClientDropped checks that a client that stops reading is dropped
+// from the room without breaking later sends to it.
+func TestSlowClientDropped(t *testing.T) {
+	r := newRoom("slow")
+	slow := &Client{id: "slow", send: make(chan []byte, 4)}
+	r.join(slow)
+	for i := 0; i < 10; i++ {
+		r.broadcast(TagText, fmt.Sprint("hi ", i))
+	}
+	if r.clients[slow] {
+		t.Fatal("slow client is still in the room")
+	}
+	for range slow.send {
+	}
+	// The channel is closed; sending again must not panic.
+	r.send(slow, TagText, "late")
+	r.broadcast(TagText, "late")
+}
+
+// TestResumeLongReplay checks that a client resuming after more changes
+// than fit in its send buffer gets a snapshot instead of being dropped.
+func TestResumeLongReplay(t *testing.T) {
+	data, err := ioutil.ReadFile("../format/testdata/wsj.puz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	puzzle, err := format.Parse(data, "wsj-2021-7-31", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newRoom("replay")
+	r.setPuzzle(puzzle)
+	first := &Client{id: "first", send: make(chan []byte, 256)}
+	r.join(first)
+	var token string
+	for message := range drainClient(first) {
+		var msg Message
+		json.Unmarshal(message, &msg)
+		if msg.Tag == TagRegister {
+			var register Register
+			json.Unmarshal(msg.Data, &register)
+			token = register.Token
+		}
+	}
+	r.leave(first)
+
+	other := &Client{id: "other", send: make(chan []byte, 1024)}
+	r.join(other)
+	player := r.players[other.id]
+	for i := 0; i < 300; i++ {
+		r.setPlayerPosition(player, i%15, (i/15)%15, Across)
+		r.flush()
+	}
+	var seq uint64
+	for _, event := range r.events {
+		if event.seq != 0 {
+			seq = event.seq - 1
+			break
+		}
+	}
+
+	resumed := &Client{id: "resumed", send: make(chan []byte, 256)}
+	resumed.resume = resumeRequest{token: token, seq: seq, hasSeq: true}
+	r.join(resumed)
+	if !r.clients[resumed] {
+		t.Fatal("resumed client was dropped")
+	}
+	if resumed.id != "first" {
+		t.Fatalf("resumed as %v, want first", resumed.id)
+	}
+	snapshot := false
+	for message := range drainClient(resumed) {
+		var msg Message
+		json.Unmarshal(message, &msg)
+		snapshot = snapshot || msg.Tag == TagSnapshot
+	}
+	if !snapshot {
+		t.Error("resumed client was not sent a snapshot")
+	}
+}
+
+// drainClient returns the messages queued for a client without a writer.
+func drainClient(client *Client) chan []byte {
+	messages := make(chan []byte, len(client.send))
+	for len(client.send) > 0 {
+		messages <- <-client.send
+	}
+	close(messages)
+	return messages
+}
+
+// TestHubShutdown checks that shutting down saves rooms whose clients are
+// still connected, without waiting for the next periodic save.
+func TestHubShutdown(t *testing.T) {
+	store, err := NewRoomStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, hub, puzzle := testHubServer(t, store)
+	conn, err := dial(server, "shutdown", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := readRegister(conn); err != nil {
+		t.Fatal(err)
+	}
+	conn.WriteJSON(message(TagPuzzleLoad, PuzzleLoad{ID: puzzle.ID}))
+	var loaded Puzzle
+	if err := readTag(conn, TagPuzzle, &loaded); err != nil {
+		t.Fatal(err)
+	}
+	conn.WriteJSON(message(TagPlayerAction, "q"))
+	var snapshot Snapshot
+	conn.WriteJSON(message(TagSnapshot, SyncRequest{}))
+	if err := readTag(conn, TagSnapshot, &snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	hub.Shutdown()
+	if hub.Room("/ws/shutdown") != nil {
+		t.Error("room is still open")
+	}
+	saved, err := store.Load("/ws/shutdown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.PuzzleID != puzzle.ID || saved.State[0] != "Q" {
+		t.Errorf("saved %q with first cell %q, want %q with Q", saved.PuzzleID, saved.State[0], puzzle.ID)
+	}
+}
+
+// TestPerceivedLightness checks the 0-1 lightness scale, including that dark
+// colors below the CIE threshold stay dark rather than jumping to 100 times
+// their lightness.
+func TestPerceivedLightness(t *testing.T) {
+	gray := func(v float64) Color { return Color{v, v, v, 1} }
+	tests := []struct {
+		name  string
+		color Color
+		want  float64
+	}{
+		{"white", gray(1), 1},
+		{"middle gray", gray(0.4663), 0.5},
+		// These have luminances below the threshold.
+		{"dark gray", gray(0.0557), 0.0404},
+		{"dark blue", Color{0, 0, 0.2, 1}, 0.0286},
+	}
+	for _, test := range tests {
+		if got := perceivedLightness(test.color); math.Abs(got-test.want) > 0.002 {
+			t.Errorf("%v: lightness %.4f, want %.4f", test.name, got, test.want)
+		}
+	}
+
+	// The linear and cube-root pieces meet at the threshold.
+	below := gray(0.0920)
+	above := gray(0.0924)
+	if d := perceivedLightness(above) - perceivedLightness(below); d < 0 || d > 0.002 {
+		t.Errorf("lightness jumps by %v at the threshold", d)
+	}
+
+	// A dark choice is lightened into the readable range, not darkened.
+	color, err := playerColor(gray(0.05))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lightness := perceivedLightness(color); lightness < minPlayerLightness-0.01 || lightness > maxPlayerLightness {
+		t.Errorf("dark color became lightness %.3f", lightness)
+	}
+}
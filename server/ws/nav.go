@@ -0,0 +1,187 @@
+package ws
+
+// clueCount returns the number of across and down clues.
+func (r *Room) clueCount() int {
+	return len(r.puzzle.AcrossClues) + len(r.puzzle.DownClues)
+}
+
+// clue returns a clue by its position in numbering order, with the across
+// clues before the down clues.
+func (r *Room) clue(i int) (Clue, Direction) {
+	if i < len(r.puzzle.AcrossClues) {
+		return r.puzzle.AcrossClues[i], Across
+	}
+	return r.puzzle.DownClues[i-len(r.puzzle.AcrossClues)], Down
+}
+
+// clueAt returns the position in numbering order of the clue whose word is
+// under a cursor.
+func (r *Room) clueAt(position Position) (int, bool) {
+	clues, offset := r.puzzle.AcrossClues, 0
+	if position.Dir == Down {
+		clues, offset = r.puzzle.DownClues, len(r.puzzle.AcrossClues)
+	}
+	for i, clue := range clues {
+		if position.Dir == Across && position.Row == clue.Row &&
+			position.Col >= clue.Column && position.Col < clue.Column+clue.Length {
+			return offset + i, true
+		}
+		if position.Dir == Down && position.Col == clue.Column &&
+			position.Row >= clue.Row && position.Row < clue.Row+clue.Length {
+			return offset + i, true
+		}
+	}
+	return 0, false
+}
+
+// clueCells returns the cells of a clue's word.
+func (r *Room) clueCells(i int) []int {
+	clue, dir := r.clue(i)
+	var cells []int
+	for k := 0; k < clue.Length; k++ {
+		row, col := clue.Row, clue.Column+k
+		if dir == Down {
+			row, col = clue.Row+k, clue.Column
+		}
+		index, ok := r.cellIndex(row, col)
+		if !ok {
+			break
+		}
+		cells = append(cells, index)
+	}
+	return cells
+}
+
+// unfilled reports whether a cell still needs an entry.
+func (r *Room) unfilled(index int) bool {
+	return r.state[index] == "" && !r.isFree(index)
+}
+
+// firstUnfilled returns the first cell of a clue's word that needs an entry,
+// or its first cell if it is full.
+func (r *Room) firstUnfilled(i int) (int, bool) {
+	cells := r.clueCells(i)
+	for _, index := range cells {
+		if r.unfilled(index) {
+			return index, true
+		}
+	}
+	if len(cells) == 0 {
+		return 0, false
+	}
+	return cells[0], true
+}
+
+// nextClue returns the clue step places after clue i in numbering order,
+// wrapping around. If unfilled is set, clues whose words are full are
+// skipped, and i itself is returned last.
+func (r *Room) nextClue(i, step int, unfilled bool) (int, bool) {
+	n := r.clueCount()
+	for s := 1; s <= n; s++ {
+		j := ((i+step*s)%n + n) % n
+		if !unfilled {
+			return j, true
+		}
+		for _, index := range r.clueCells(j) {
+			if r.unfilled(index) {
+				return j, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// moveTo moves a player's cursor to a cell.
+func (r *Room) moveTo(player *Player, index int, dir Direction) {
+	r.setPlayerPosition(player, index/r.width, index%r.width, dir)
+}
+
+// jumpClue moves a player to the clue step places from theirs, for Tab and
+// Shift-Tab.
+func (r *Room) jumpClue(player *Player, step int) {
+	if r.clueCount() == 0 {
+		return
+	}
+	i, ok := r.clueAt(player.Position)
+	if !ok {
+		// Start from the first or last clue.
+		i = 0
+		if step > 0 {
+			i = -1
+		}
+	}
+	j, _ := r.nextClue(i, step, false)
+	if index, ok := r.firstUnfilled(j); ok {
+		_, dir := r.clue(j)
+		r.moveTo(player, index, dir)
+	}
+}
+
+// jumpWord moves a player to the first or last cell of their word, for Home
+// and End.
+func (r *Room) jumpWord(player *Player, last bool) {
+	cells := r.wordCells(player.Position)
+	if len(cells) == 0 {
+		return
+	}
+	index := cells[0]
+	if last {
+		index = cells[len(cells)-1]
+	}
+	r.moveTo(player, index, player.Position.Dir)
+}
+
+// advance moves a player on after an entry: to the next cell of their word,
+// or the next empty one if the room skips filled cells, and after the end of
+// the word to the first empty cell of the next clue that needs entries.
+func (r *Room) advance(player *Player) {
+	position := player.Position
+	index, inGrid := r.cellIndex(position.Row, position.Col)
+	i, ok := r.clueAt(position)
+	if !inGrid || !ok {
+		if position.Dir == Across {
+			r.setPlayerPosition(player, position.Row, position.Col+1, position.Dir)
+		} else {
+			r.setPlayerPosition(player, position.Row+1, position.Col, position.Dir)
+		}
+		return
+	}
+	cells := r.clueCells(i)
+	k := 0
+	for k < len(cells) && cells[k] != index {
+		k++
+	}
+	skip := r.settings.SkipFilled
+	for j := k + 1; j < len(cells); j++ {
+		if !skip || r.unfilled(cells[j]) {
+			r.moveTo(player, cells[j], position.Dir)
+			return
+		}
+	}
+	if j, ok := r.nextClue(i, 1, true); ok {
+		if index, ok := r.firstUnfilled(j); ok {
+			_, dir := r.clue(j)
+			r.moveTo(player, index, dir)
+			return
+		}
+	}
+	// The grid is full, so stay put.
+	r.setPlayerPosition(player, position.Row, position.Col, position.Dir)
+}
+
+// backspace clears the player's cell, or if it is already empty, moves back
+// a cell and clears that one.
+func (r *Room) backspace(player *Player) {
+	row, col, dir := player.Position.Row, player.Position.Col, player.Position.Dir
+	if index, ok := r.cellIndex(row, col); ok && r.state[index] != "" && r.editable(index) {
+		r.setCellValue(row, col, "", player)
+		r.setPlayerPosition(player, row, col, dir)
+		return
+	}
+	if dir == Across {
+		r.setPlayerPosition(player, row, col-1, dir)
+	} else {
+		r.setPlayerPosition(player, row-1, col, dir)
+	}
+	r.setCellValue(player.Position.Row, player.Position.Col, "", player)
+}
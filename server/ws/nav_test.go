@@ -0,0 +1,54 @@
+package ws
+
+import "testing"
+
+// TestNavigation checks where Tab, Shift-Tab, Home and End move a player's
+// cursor in the grid
+//
+//	A B C
+//	D . E
+//	F G H
+//
+// whose clues are 1-Across, 3-Across, 1-Down and 2-Down.
+func TestNavigation(t *testing.T) {
+	tests := []struct {
+		name   string
+		start  Position
+		filled []int
+		key    string
+		want   Position
+	}{
+		{"tab", Position{0, 1, Across}, nil, KeyTab, Position{2, 0, Across}},
+		{"tab to down", Position{2, 1, Across}, nil, KeyTab, Position{0, 0, Down}},
+		{"tab wraps", Position{1, 2, Down}, nil, KeyTab, Position{0, 0, Across}},
+		{"tab to empty cell", Position{0, 0, Across}, []int{6, 7}, KeyTab, Position{2, 2, Across}},
+		{"tab to full word", Position{0, 0, Across}, []int{6, 7, 8}, KeyTab, Position{2, 0, Across}},
+		{"tab from block", Position{1, 1, Across}, nil, KeyTab, Position{0, 0, Across}},
+		{"shift tab", Position{2, 1, Across}, nil, KeyShiftTab, Position{0, 0, Across}},
+		{"shift tab wraps", Position{0, 1, Across}, nil, KeyShiftTab, Position{0, 2, Down}},
+		{"shift tab from block", Position{1, 1, Across}, nil, KeyShiftTab, Position{0, 2, Down}},
+		{"home", Position{0, 2, Across}, nil, KeyHome, Position{0, 0, Across}},
+		{"end", Position{0, 0, Across}, nil, KeyEnd, Position{0, 2, Across}},
+		{"home down", Position{2, 2, Down}, nil, KeyHome, Position{0, 2, Down}},
+		{"end down", Position{1, 0, Down}, nil, KeyEnd, Position{2, 0, Down}},
+		{"end filled", Position{0, 0, Across}, []int{2}, KeyEnd, Position{0, 2, Across}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newRoom("nav")
+			r.setPuzzle(testPuzzle("nav", "ABCD.EFGH", 3, 3))
+			r.join(&Client{id: "a", send: make(chan []byte, 256)})
+			player := r.players["a"]
+			for _, index := range test.filled {
+				r.state[index] = r.puzzle.Grid[index : index+1]
+			}
+			player.Position = test.start
+			if err := r.handlePlayerAction(player, test.key); err != nil {
+				t.Fatal(err)
+			}
+			if player.Position != test.want {
+				t.Errorf("moved to %+v, want %+v", player.Position, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package ws
+
+// Player actions that aren't keys. ActionPencil toggles the player's pencil
+// mode, and ActionCommitPencil inks the pencil entries in the player's word.
+const (
+	ActionPencil       = "Pencil"
+	ActionCommitPencil = "CommitPencil"
+)
+
+// commitPencil clears the pencil flag from the entries in a player's word.
+func (r *Room) commitPencil(player *Player) {
+	if r.solved {
+		return
+	}
+	for _, index := range r.wordCells(player.Position) {
+		if r.flags[index]&FlagPencil != 0 {
+			r.setFlags(index, r.flags[index]&^FlagPencil)
+		}
+	}
+}
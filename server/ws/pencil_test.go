@@ -0,0 +1,48 @@
+package ws
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestPencil checks that entries typed in pencil mode are marked as pencil
+// until inked, either by committing the word they're in or by typing over
+// them in ink.
+func TestPencil(t *testing.T) {
+	tests := []struct {
+		name  string
+		keys  string
+		state string
+		// pencil is the pencil mark of each cell of the first row.
+		pencil string
+	}{
+		{"pencil", "Pencil a b", "AB ", "PP-"},
+		{"ink", "a b", "AB ", "---"},
+		{"toggle", "Pencil a Pencil b", "AB ", "P--"},
+		{"commit", "Pencil a b CommitPencil", "AB ", "---"},
+		{"commit other word", "Pencil a b ArrowDown CommitPencil", "AB ", "PP-"},
+		{"ink over", "Pencil a b Pencil ArrowLeft b", "AB ", "P--"},
+		{"erase", "Pencil a b ArrowLeft Backspace Backspace", "   ", "---"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newRoom("pencil")
+			r.setPuzzle(testPuzzle("pencil", "ABCDEF", 3, 2))
+			r.join(&Client{id: "a", send: make(chan []byte, 256)})
+			player := r.players["a"]
+			for _, key := range strings.Fields(test.keys) {
+				if err := r.handlePlayerAction(player, key); err != nil {
+					t.Fatalf("%v: %v", key, err)
+				}
+			}
+			r.flush()
+			for i := 0; i < 3; i++ {
+				value := strings.TrimSpace(test.state[i : i+1])
+				pencil := test.pencil[i] == 'P'
+				if r.state[i] != value || r.isPencil(i) != pencil {
+					t.Errorf("cell %d holds %q, pencil: %v; want %q, %v", i, r.state[i], r.isPencil(i), value, pencil)
+				}
+			}
+		})
+	}
+}
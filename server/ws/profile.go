@@ -0,0 +1,197 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+)
+
+const (
+	// maxNameLength is the longest display name, in runes.
+	maxNameLength = 24
+
+	// Player colors are drawn behind black letters on white cells, so they
+	// must be neither too dark nor too light.
+	minPlayerLightness = 0.45
+	maxPlayerLightness = 0.85
+
+	// minColorDistance is how far apart two players' colors must be.
+	minColorDistance = 0.12
+)
+
+// Errors returned when a profile update is rejected.
+var (
+	ErrProfileName  = errors.New("Name must be printable and non-empty")
+	ErrProfileColor = errors.New("Color is too close to another player's")
+)
+
+// Profile sets a player's display name and color. Empty fields are left
+// unchanged.
+type Profile struct {
+	Name  string `json:"name,omitempty"`
+	Color *Color `json:"color,omitempty"`
+}
+
+func (s *Subscription) handleProfile(input json.RawMessage) error {
+	var profile Profile
+	if err := json.Unmarshal([]byte(input), &profile); err != nil {
+		return err
+	}
+	return s.actor.call(func(room *Room) error {
+		player := room.players[s.client.id]
+		if player == nil {
+			return errors.New("Player is nil.")
+		}
+		err := room.setProfile(player, profile)
+		// Send the player's profile either way, so a rejected change is
+		// undone on the client.
+		room.markPlayer(player.ID)
+		room.flush()
+		return err
+	})
+}
+
+// setProfile validates and applies a profile update. A name already taken
+// by another player gets a number appended, and a color is brought into the
+// readable lightness range.
+func (r *Room) setProfile(player *Player, profile Profile) error {
+	var name string
+	var color Color
+	if profile.Name != "" {
+		var err error
+		if name, err = cleanName(profile.Name); err != nil {
+			return err
+		}
+		name = r.uniqueName(player.ID, name)
+	}
+	if profile.Color != nil {
+		var err error
+		if color, err = playerColor(*profile.Color); err != nil {
+			return err
+		}
+		if r.colorTaken(player.ID, color) {
+			return ErrProfileColor
+		}
+	}
+	if name != "" {
+		player.Name = name
+	}
+	if profile.Color != nil {
+		player.Color = color
+	}
+	return nil
+}
+
+// cleanName trims a display name and checks that it is printable.
+func cleanName(name string) (string, error) {
+	name = strings.Join(strings.Fields(name), " ")
+	if name == "" {
+		return "", ErrProfileName
+	}
+	for _, c := range name {
+		if !unicode.IsPrint(c) {
+			return "", ErrProfileName
+		}
+	}
+	if runes := []rune(name); len(runes) > maxNameLength {
+		name = strings.TrimSpace(string(runes[:maxNameLength]))
+	}
+	return name, nil
+}
+
+// nameTaken reports whether a player other than id uses name.
+func (r *Room) nameTaken(id, name string) bool {
+	for _, players := range []map[string]*Player{r.players, r.departed} {
+		for otherID, other := range players {
+			if otherID != id && strings.EqualFold(other.Name, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// colorTaken reports whether a player other than id uses a color too close to
+// color. Departed players count, since they may resume with their color.
+func (r *Room) colorTaken(id string, color Color) bool {
+	for _, players := range []map[string]*Player{r.players, r.departed} {
+		for otherID, other := range players {
+			if otherID != id && colorDistance(color, other.Color) < minColorDistance {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// uniqueName returns name, with a number appended if another player in the
+// room already uses it.
+func (r *Room) uniqueName(id, name string) string {
+	if !r.nameTaken(id, name) {
+		return name
+	}
+	for n := 2; ; n++ {
+		candidate := fmt.Sprintf("%s %d", name, n)
+		if !r.nameTaken(id, candidate) {
+			return candidate
+		}
+	}
+}
+
+// defaultName returns the first free "playerNN" name.
+func (r *Room) defaultName(id string) string {
+	for n := 1; ; n++ {
+		name := fmt.Sprintf("player%02d", n)
+		if !r.nameTaken(id, name) {
+			return name
+		}
+	}
+}
+
+// playerColor checks a chosen color and lightens or darkens it into the
+// readable lightness range.
+func playerColor(c Color) (Color, error) {
+	for _, v := range []float64{c.R, c.G, c.B} {
+		if math.IsNaN(v) || v < 0 || v > 1 {
+			return Color{}, fmt.Errorf("Color component %v is out of range.", v)
+		}
+	}
+	c.A = 1.0
+	if lightness := perceivedLightness(c); lightness < minPlayerLightness {
+		c = mixLightness(c, Color{1, 1, 1, 1}, minPlayerLightness, true)
+	} else if lightness > maxPlayerLightness {
+		c = mixLightness(c, Color{0, 0, 0, 1}, maxPlayerLightness, false)
+	}
+	return c, nil
+}
+
+// mixLightness mixes a color toward white or black until it reaches the
+// given perceived lightness, keeping its hue. lighten is set when toward is
+// lighter than c.
+func mixLightness(c, toward Color, lightness float64, lighten bool) Color {
+	mix := func(t float64) Color {
+		return Color{
+			c.R + t*(toward.R-c.R),
+			c.G + t*(toward.G-c.G),
+			c.B + t*(toward.B-c.B),
+			c.A,
+		}
+	}
+	lo, hi := 0.0, 1.0
+	for i := 0; i < 20; i++ {
+		t := (lo + hi) / 2
+		if (perceivedLightness(mix(t)) < lightness) == lighten {
+			lo = t
+		} else {
+			hi = t
+		}
+	}
+	return mix(hi)
+}
+
+func colorDistance(a, b Color) float64 {
+	return math.Sqrt((a.R-b.R)*(a.R-b.R) + (a.G-b.G)*(a.G-b.G) + (a.B-b.B)*(a.B-b.B))
+}
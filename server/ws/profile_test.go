@@ -0,0 +1,43 @@
+package ws
+
+import "testing"
+
+// TestProfileColorConflict checks that a color too close to that of another
+// player, connected or departed, is rejected.
+func TestProfileColorConflict(t *testing.T) {
+	red := Color{0.9, 0.3, 0.3, 1}
+	nearRed := Color{0.88, 0.32, 0.3, 1}
+	blue := Color{0.4, 0.6, 0.95, 1}
+	tests := []struct {
+		name     string
+		other    *Player
+		departed bool
+		color    Color
+		wantErr  error
+	}{
+		{"connected", &Player{ID: "b", Color: red}, false, nearRed, ErrProfileColor},
+		{"departed", &Player{ID: "b", Color: red}, true, nearRed, ErrProfileColor},
+		{"distinct", &Player{ID: "b", Color: red}, true, blue, nil},
+		{"own color", nil, false, nearRed, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newRoom("profile")
+			player := &Player{ID: "a", Color: red}
+			r.players["a"] = player
+			if test.other != nil && test.departed {
+				r.departed[test.other.ID] = test.other
+			} else if test.other != nil {
+				r.players[test.other.ID] = test.other
+			}
+			color := test.color
+			err := r.setProfile(player, Profile{Color: &color})
+			if err != test.wantErr {
+				t.Fatalf("got %v, want %v", err, test.wantErr)
+			}
+			if err == nil && colorDistance(player.Color, test.color) > 0.001 {
+				t.Errorf("color is %+v, want %+v", player.Color, test.color)
+			}
+		})
+	}
+}
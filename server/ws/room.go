@@ -0,0 +1,265 @@
+package ws
+
+import (
+	"strings"
+	"time"
+)
+
+// maxRebusLength is the longest multi-letter entry accepted for a cell.
+const maxRebusLength = 10
+
+// cellIndex returns the index of a cell in the grid and whether the cell is
+// inside it.
+func (r *Room) cellIndex(row, col int) (int, bool) {
+	if row < 0 || col < 0 || row >= r.height || col >= r.width {
+		return 0, false
+	}
+	return row*r.width + col, true
+}
+
+// stateString returns the first letter of each cell's entry, with 0 for empty
+// cells, for clients that render one character per cell.
+func (r *Room) stateString() string {
+	state := make([]byte, len(r.state))
+	for i, entry := range r.state {
+		if len(entry) > 0 {
+			state[i] = entry[0]
+		}
+	}
+	return string(state)
+}
+
+// rebusState returns the multi-letter entries in the grid, keyed by index.
+func (r *Room) rebusState() map[int]string {
+	rebus := make(map[int]string)
+	for i, entry := range r.state {
+		if len(entry) > 1 {
+			rebus[i] = entry
+		}
+	}
+	return rebus
+}
+
+// puzzleProgress is a room's saved work on a puzzle it is not showing.
+type puzzleProgress struct {
+	state          []string
+	flags          []CellFlags
+	authors        []string
+	enteredAt      []time.Time
+	stats          map[string]*PlayerStats
+	completedWords []bool
+	elapsed        time.Duration
+	solved         bool
+}
+
+// saveProgress records the work on the active puzzle in the room's history.
+func (r *Room) saveProgress() {
+	if r.puzzle.ID == "" {
+		return
+	}
+	if r.history == nil {
+		r.history = make(map[string]*puzzleProgress)
+	}
+	r.history[r.puzzle.ID] = &puzzleProgress{
+		state:          r.state,
+		flags:          r.flags,
+		authors:        r.authors,
+		enteredAt:      r.enteredAt,
+		stats:          r.stats,
+		completedWords: r.completedWords,
+		elapsed:        r.elapsed(),
+		solved:         r.solved,
+	}
+}
+
+// setPuzzle installs puzzle as the room's active puzzle and moves every
+// player back to the first cell. Work on the previous puzzle is saved, and
+// saved work on the new one is restored. It reports whether any was. The
+// timer starts on the first entry.
+func (r *Room) setPuzzle(puzzle Puzzle) bool {
+	r.saveProgress()
+	r.puzzle = puzzle
+	r.state = make([]string, len(puzzle.Grid))
+	r.flags = make([]CellFlags, len(puzzle.Grid))
+	r.authors = make([]string, len(puzzle.Grid))
+	r.enteredAt = make([]time.Time, len(puzzle.Grid))
+	r.stats = make(map[string]*PlayerStats)
+	r.completedWords = make([]bool, len(puzzle.AcrossClues)+len(puzzle.DownClues))
+	r.edits = nil
+	r.undone = nil
+	r.timer = roomTimer{}
+	r.solved = false
+	r.filled = false
+	r.height = puzzle.Height
+	r.width = puzzle.Width
+	for _, player := range r.players {
+		player.Position = Position{0, 0, Across}
+		player.RebusMode = false
+		player.RebusEntry = ""
+	}
+	saved, ok := r.history[puzzle.ID]
+	if !ok || len(saved.state) != len(r.state) {
+		// Carry on from a timer saved in the puzzle file.
+		if puzzle.Timer != nil {
+			r.timer.elapsed = time.Duration(puzzle.Timer.Elapsed) * time.Second
+		}
+		return false
+	}
+	copy(r.state, saved.state)
+	copy(r.flags, saved.flags)
+	copy(r.authors, saved.authors)
+	copy(r.enteredAt, saved.enteredAt)
+	copy(r.completedWords, saved.completedWords)
+	if saved.stats != nil {
+		r.stats = saved.stats
+	}
+	// The timer stays paused until someone types.
+	r.timer.elapsed = saved.elapsed
+	if saved.solved {
+		r.solved = true
+		r.timer.reason = PausedSolved
+	}
+	r.filled = r.isFilled()
+	return true
+}
+
+// restoreState fills the grid from a state string in the format sent in
+// PlayerUpdate, where empty cells are 0 or a space. Rebus entries override
+// the first letters in the string.
+func (r *Room) restoreState(state string, rebus map[int]string) bool {
+	if len(state) != len(r.state) {
+		return false
+	}
+	for i := 0; i < len(state); i++ {
+		c := state[i]
+		switch {
+		case r.puzzle.Grid[i] == '.':
+			r.state[i] = ""
+		case c >= 'a' && c <= 'z':
+			r.state[i] = string(c - 32)
+		case (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9'):
+			r.state[i] = string(c)
+		default:
+			r.state[i] = ""
+		}
+	}
+	for i, entry := range rebus {
+		entry = strings.ToUpper(entry)
+		if i >= 0 && i < len(r.state) && r.puzzle.Grid[i] != '.' && validRebusEntry(entry) {
+			r.state[i] = entry
+		}
+	}
+	return true
+}
+
+func (r *Room) playerUpdate() PlayerUpdate {
+	return PlayerUpdate{
+		State:   r.stateString(),
+		Rebus:   r.rebusState(),
+		Flags:   r.flags,
+		Players: r.players,
+	}
+}
+
+// isSolved reports whether every cell is filled in correctly.
+func (r *Room) isSolved() bool {
+	if r.puzzle.Grid == "" || len(r.state) != len(r.puzzle.Grid) {
+		return false
+	}
+	for i := range r.state {
+		if r.puzzle.Grid[i] != '.' && !r.isCorrect(i) {
+			return false
+		}
+	}
+	return true
+}
+
+// completion returns the fraction of white cells that are filled in.
+func completion(grid string, state []string) float64 {
+	cells, filled := 0, 0
+	for i := 0; i < len(grid) && i < len(state); i++ {
+		if grid[i] == '.' {
+			continue
+		}
+		cells++
+		if state[i] != "" {
+			filled++
+		}
+	}
+	if cells == 0 {
+		return 0
+	}
+	return float64(filled) / float64(cells)
+}
+
+// addProgress fills in the room's work on a listed puzzle, if any.
+func (r *Room) addProgress(data *PuzzleData, puzzle Puzzle) {
+	state := r.state
+	if puzzle.ID != r.puzzle.ID {
+		saved, ok := r.history[puzzle.ID]
+		if !ok {
+			return
+		}
+		state = saved.state
+	}
+	letters := make([]byte, len(state))
+	for i, entry := range state {
+		if len(entry) > 0 {
+			letters[i] = entry[0]
+		}
+	}
+	data.State = string(letters)
+	data.Completion = completion(puzzle.Grid, state)
+}
+
+// solution returns the expected entry for a cell, using the rebus table for
+// multi-letter squares.
+func (r *Room) solution(index int) string {
+	if answer, ok := r.puzzle.Rebus[index]; ok {
+		return answer
+	}
+	return r.puzzle.Grid[index : index+1]
+}
+
+// isCorrect reports whether the entry in a cell matches its solution. A
+// rebus cell also accepts the first letter of its answer, as stored in the
+// grid, and a cell without a letter in the solution accepts anything.
+func (r *Room) isCorrect(index int) bool {
+	if r.isFree(index) {
+		return true
+	}
+	entry := r.state[index]
+	if strings.EqualFold(entry, r.solution(index)) {
+		return true
+	}
+	_, rebus := r.puzzle.Rebus[index]
+	return rebus && strings.EqualFold(entry, r.puzzle.Grid[index:index+1])
+}
+
+// isFree reports whether a white cell has no letter in the solution, so it
+// may be left blank.
+func (r *Room) isFree(index int) bool {
+	if _, ok := r.puzzle.Rebus[index]; ok {
+		return false
+	}
+	c := r.puzzle.Grid[index]
+	return c != '.' && (c < 'A' || c > 'Z') && (c < 'a' || c > 'z') && (c < '0' || c > '9')
+}
+
+// elapsed returns the solving time.
+func (r *Room) elapsed() time.Duration {
+	return r.timer.total(time.Now())
+}
+
+// validRebusEntry reports whether entry may be committed to a rebus cell.
+func validRebusEntry(entry string) bool {
+	if len(entry) == 0 || len(entry) > maxRebusLength {
+		return false
+	}
+	for _, c := range entry {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,90 @@
+package ws
+
+import (
+	"testing"
+	"time"
+)
+
+// TestPuzzleHistory checks that switching puzzles keeps the work on each,
+// and that switching back restores its fill, time and whether it was solved.
+func TestPuzzleHistory(t *testing.T) {
+	first := testPuzzle("first", "AB.D", 4, 1)
+	second := testPuzzle("second", "XY", 2, 1)
+	r := newRoom("history")
+	r.join(&Client{id: "a", send: make(chan []byte, 256)})
+	player := r.players["a"]
+
+	r.setPuzzle(first)
+	r.handlePlayerAction(player, "a")
+	r.handlePlayerAction(player, "b")
+	r.timer.elapsed = time.Minute
+	r.pauseTimer(time.Now(), PausedByPlayer)
+	if r.setPuzzle(second) {
+		t.Error("work on a new puzzle was restored")
+	}
+	r.handlePlayerAction(player, "x")
+	r.handlePlayerAction(player, "y")
+	r.flush()
+	if !r.solved {
+		t.Fatal("second puzzle was not solved")
+	}
+
+	steps := []struct {
+		puzzle  Puzzle
+		state   []string
+		elapsed time.Duration
+		solved  bool
+	}{
+		{first, []string{"A", "B", "", ""}, time.Minute, false},
+		{second, []string{"X", "Y"}, 0, true},
+		{first, []string{"A", "B", "", ""}, time.Minute, false},
+	}
+	for _, step := range steps {
+		if !r.setPuzzle(step.puzzle) {
+			t.Fatalf("work on %v was not restored", step.puzzle.ID)
+		}
+		for i, entry := range step.state {
+			if r.state[i] != entry {
+				t.Errorf("%v: cell %d holds %q, want %q", step.puzzle.ID, i, r.state[i], entry)
+			}
+		}
+		if r.elapsed() < step.elapsed || r.timer.running() {
+			t.Errorf("%v: timer at %v, running: %v; want %v, stopped", step.puzzle.ID, r.elapsed(), r.timer.running(), step.elapsed)
+		}
+		if r.solved != step.solved {
+			t.Errorf("%v: solved: %v, want %v", step.puzzle.ID, r.solved, step.solved)
+		}
+	}
+}
+
+// TestAddProgress checks the room's work sent with each listed puzzle: its
+// first letters and the fraction of white cells filled.
+func TestAddProgress(t *testing.T) {
+	r := newRoom("progress")
+	r.join(&Client{id: "a", send: make(chan []byte, 256)})
+	player := r.players["a"]
+	saved := testPuzzle("saved", "AB.D", 4, 1)
+	r.setPuzzle(saved)
+	r.handlePlayerAction(player, "a")
+	r.setCellValue(0, 3, "DE", player)
+	active := testPuzzle("active", "XY", 2, 1)
+	r.setPuzzle(active)
+	r.handlePlayerAction(player, "x")
+
+	tests := []struct {
+		puzzle     Puzzle
+		state      string
+		completion float64
+	}{
+		{saved, "A\x00\x00D", 2.0 / 3},
+		{active, "X\x00", 0.5},
+		{testPuzzle("unseen", "AB", 2, 1), "", 0},
+	}
+	for _, test := range tests {
+		data := PuzzleData{ID: test.puzzle.ID}
+		r.addProgress(&data, test.puzzle)
+		if data.State != test.state || data.Completion != test.completion {
+			t.Errorf("%v: got %q, %v complete; want %q, %v", test.puzzle.ID, data.State, data.Completion, test.state, test.completion)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package ws
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/tmngo/crossword-server/util"
+)
+
+// sessionGrace is how long a departed player can reconnect as themselves.
+const sessionGrace = 5 * time.Minute
+
+// maxEvents is the number of recent room events kept for replay.
+const maxEvents = 256
+
+// replayReserve is the room kept in a resumed client's send buffer for the
+// messages sent after its replay.
+const replayReserve = 8
+
+// session ties a resumable token to a player.
+type session struct {
+	PlayerID string `json:"playerId"`
+	// LeftAt is zero while the player is connected.
+	LeftAt time.Time `json:"leftAt"`
+	// LeftPos is the event log position when the player left.
+	LeftPos uint64 `json:"leftPos"`
+}
+
+// resumeRequest is what a reconnecting client sends in its URL query, as
+// "?token=<token>&seq=<seq>". Seq is the last delta the client applied.
+type resumeRequest struct {
+	token  string
+	seq    uint64
+	hasSeq bool
+}
+
+func parseResumeRequest(query url.Values) resumeRequest {
+	request := resumeRequest{token: query.Get("token")}
+	if seq, err := strconv.ParseUint(query.Get("seq"), 10, 64); err == nil {
+		request.seq = seq
+		request.hasSeq = true
+	}
+	return request
+}
+
+// roomEvent is a message sent to the whole room. Seq is the room's sequence
+// number for state changes and zero for chat.
+type roomEvent struct {
+	pos     uint64
+	seq     uint64
+	message []byte
+}
+
+// logEvent records a broadcast message for replay to reconnecting clients.
+func (r *Room) logEvent(seq uint64, message []byte) {
+	r.eventPos++
+	r.events = append(r.events, roomEvent{r.eventPos, seq, message})
+	if len(r.events) > maxEvents {
+		r.events = r.events[len(r.events)-maxEvents:]
+	}
+}
+
+// resume returns the player a client's token belongs to, if the player can
+// still be resumed.
+func (r *Room) resume(token string) (*Player, *session) {
+	s, ok := r.sessions[hashToken(token)]
+	if !ok {
+		return nil, nil
+	}
+	if player, ok := r.players[s.PlayerID]; ok {
+		// The old connection hasn't been noticed to have dropped yet.
+		return player, s
+	}
+	if player, ok := r.departed[s.PlayerID]; ok && time.Since(s.LeftAt) < sessionGrace {
+		return player, s
+	}
+	return nil, nil
+}
+
+// expireSessions forgets sessions whose grace window has passed.
+func (r *Room) expireSessions() {
+	for token, s := range r.sessions {
+		if !s.LeftAt.IsZero() && time.Since(s.LeftAt) >= sessionGrace {
+			delete(r.sessions, token)
+		}
+	}
+}
+
+// replay sends a resumed client the room events it missed. Clients that
+// name the last delta they applied are resumed in delta mode. The client's
+// writer hasn't started yet, so a replay too long for its send buffer is
+// replaced by a snapshot.
+func (r *Room) replay(client *Client, s *session, request resumeRequest) {
+	if request.hasSeq {
+		r.deltaClients[client] = true
+		var missed [][]byte
+		for _, event := range r.events {
+			if event.seq > request.seq || (event.seq == 0 && event.pos > s.LeftPos) {
+				missed = append(missed, event.message)
+			}
+		}
+		if len(r.events) == 0 || !r.replayable(request.seq) || len(missed) > r.sendSpace(client) {
+			r.send(client, TagSnapshot, r.stateSnapshot())
+			r.replayChat(client, s.LeftPos)
+			return
+		}
+		for _, message := range missed {
+			r.sendMessage(client, message)
+		}
+		return
+	}
+	r.send(client, TagPlayerUpdate, r.playerUpdate())
+	r.replayChat(client, s.LeftPos)
+}
+
+// sendSpace returns the number of messages that can be queued for a client
+// while leaving replayReserve free.
+func (r *Room) sendSpace(client *Client) int {
+	return cap(client.send) - len(client.send) - replayReserve
+}
+
+// replayable reports whether the event log holds every change after seq.
+func (r *Room) replayable(seq uint64) bool {
+	if seq == r.seq {
+		return true
+	}
+	for _, event := range r.events {
+		if event.seq != 0 {
+			return event.seq <= seq+1
+		}
+	}
+	return false
+}
+
+// replayChat sends the chat messages logged after pos. If the log no longer
+// reaches back that far, the room's whole chat history is sent. The oldest
+// messages are dropped if they would overflow the client's send buffer.
+func (r *Room) replayChat(client *Client, pos uint64) {
+	var messages [][]byte
+	if len(r.events) > 0 && r.events[0].pos > pos+1 {
+		for _, text := range r.chat {
+			message, err := json.Marshal(TaggedMessage{TagText, text})
+			if err != nil {
+				log.Printf("Error encoding chat: %v", err)
+				continue
+			}
+			messages = append(messages, message)
+		}
+	} else {
+		for _, event := range r.events {
+			if event.seq == 0 && event.pos > pos {
+				messages = append(messages, event.message)
+			}
+		}
+	}
+	if space := r.sendSpace(client); len(messages) > space {
+		if space < 0 {
+			space = 0
+		}
+		messages = messages[len(messages)-space:]
+	}
+	for _, message := range messages {
+		r.sendMessage(client, message)
+	}
+}
+
+// hashToken returns the key a session is stored under. Tokens are bearer
+// credentials, so only their hashes are kept, and saved with the room.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newSession issues a token for a player.
+func (r *Room) newSession(playerID string) string {
+	token := util.NewId(24)
+	r.sessions[hashToken(token)] = &session{PlayerID: playerID}
+	return token
+}
+
+// endSession marks a departed player's sessions as resumable until the grace
+// window passes.
+func (r *Room) endSession(playerID string) {
+	for _, s := range r.sessions {
+		if s.PlayerID == playerID && s.LeftAt.IsZero() {
+			s.LeftAt = time.Now()
+			s.LeftPos = r.eventPos
+		}
+	}
+}
+
+// logMessage encodes a tagged message and records it for replay.
+func (r *Room) logMessage(seq uint64, tag MessageTag, data interface{}) ([]byte, error) {
+	message, err := json.Marshal(TaggedMessage{tag, data})
+	if err != nil {
+		log.Printf("Error encoding event: %v", err)
+		return nil, err
+	}
+	r.logEvent(seq, message)
+	return message, nil
+}
@@ -0,0 +1,106 @@
+package ws
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// TestResume checks that a client reconnecting with its token within the
+// grace window is given back its player, and is sent the chat it missed,
+// while any other client joins as a new player.
+func TestResume(t *testing.T) {
+	tests := []struct {
+		name string
+		// leave is whether the first connection is closed before resuming.
+		leave bool
+		// away is how long ago the player left.
+		away    time.Duration
+		token   string
+		resumed bool
+	}{
+		{"departed", true, 0, "", true},
+		{"still connected", false, 0, "", true},
+		{"grace passed", true, sessionGrace, "", false},
+		{"unknown token", true, 0, "unknown", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newRoom("resume")
+			r.setPuzzle(testPuzzle("resume", "ABCD", 4, 1))
+			first := &Client{id: "first", send: make(chan []byte, 256)}
+			r.join(first)
+			var register Register
+			for message := range drainClient(first) {
+				var msg Message
+				json.Unmarshal(message, &msg)
+				if msg.Tag == TagRegister {
+					json.Unmarshal(msg.Data, &register)
+				}
+			}
+			player := r.players["first"]
+			player.Name = "Ann"
+			player.Position = Position{0, 2, Down}
+			color := player.Color
+			say := func(text string) {
+				r.chat = append(r.chat, text)
+				message, _ := r.logMessage(0, TagText, text)
+				r.broadcastMessage(message)
+			}
+			say("before")
+			if test.leave {
+				r.leave(first)
+				for _, s := range r.sessions {
+					s.LeftAt = s.LeftAt.Add(-test.away)
+				}
+			}
+			say("after")
+
+			token := register.Token
+			if test.token != "" {
+				token = test.token
+			}
+			second := &Client{id: "second", send: make(chan []byte, 256)}
+			second.resume = resumeRequest{token: token}
+			r.join(second)
+			if got := second.id == "first"; got != test.resumed {
+				t.Fatalf("resumed: %v, want %v", got, test.resumed)
+			}
+			if len(r.clients) != 1 {
+				t.Errorf("room has %d clients, want 1", len(r.clients))
+			}
+			var chat []string
+			for message := range drainClient(second) {
+				var msg Message
+				json.Unmarshal(message, &msg)
+				if msg.Tag == TagText {
+					var text string
+					json.Unmarshal(msg.Data, &text)
+					chat = append(chat, text)
+				}
+			}
+			if !test.resumed {
+				if _, ok := r.sessions[hashToken(register.Token)]; ok == (test.away >= sessionGrace) {
+					t.Errorf("expired session kept: %v", ok)
+				}
+				return
+			}
+			resumed := r.players["first"]
+			if resumed != player || resumed.Name != "Ann" || resumed.Color != color || resumed.Position != (Position{0, 2, Down}) {
+				t.Errorf("resumed as %+v, want %+v", resumed, player)
+			}
+			if len(r.departed) != 0 {
+				t.Errorf("resumed player is still departed")
+			}
+			// A player who never noticed the dropped connection has already
+			// seen the chat.
+			want := 1
+			if !test.leave {
+				want = 0
+			}
+			if len(chat) != want || (want > 0 && chat[0] != "after") {
+				t.Errorf("replayed chat %q, want only the message sent while away", chat)
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package ws
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tmngo/crossword-server/format"
+)
+
+// Errors returned when fetching a puzzle from a source.
+var (
+	ErrUnknownSource = errors.New("Unknown puzzle source")
+	ErrNotPublished  = errors.New("No puzzle is published on that date")
+	ErrNotFound      = errors.New("Puzzle not found")
+)
+
+// Source is a publisher of dated puzzles.
+type Source interface {
+	// ID is the short name clients use to request the source, such as "wsj".
+	ID() string
+	// Name is the display name of the source.
+	Name() string
+	// Format is the file format of the source's puzzles, such as format.Puz.
+	Format() string
+	// Publishes reports whether a puzzle is published on date.
+	Publishes(date time.Time) bool
+	// Fetch returns the puzzle file published on date.
+	Fetch(date time.Time) ([]byte, error)
+}
+
+// URLSource is a Source that downloads each puzzle from a URL derived from
+// its date.
+type URLSource struct {
+	SourceID   string
+	SourceName string
+	FileFormat string
+	// Days are the weekdays the source publishes on. If empty, the source
+	// publishes every day.
+	Days []time.Weekday
+	URL  func(date time.Time) string
+	// Client is used for downloads. If nil, a default client is used.
+	Client *http.Client
+}
+
+func (s *URLSource) ID() string     { return s.SourceID }
+func (s *URLSource) Name() string   { return s.SourceName }
+func (s *URLSource) Format() string { return s.FileFormat }
+
+func (s *URLSource) Publishes(date time.Time) bool {
+	if len(s.Days) == 0 {
+		return true
+	}
+	for _, day := range s.Days {
+		if date.Weekday() == day {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *URLSource) Fetch(date time.Time) ([]byte, error) {
+	client := s.Client
+	if client == nil {
+		client = httpClient
+	}
+	resp, err := client.Get(s.URL(date))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Fetching %v: %v", s.SourceID, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// WallStreetJournal is the Wall Street Journal puzzle, from an archive
+// mirror.
+var WallStreetJournal = &URLSource{
+	SourceID:   "wsj",
+	SourceName: "The Wall Street Journal",
+	FileFormat: format.Puz,
+	Days: []time.Weekday{
+		time.Monday, time.Tuesday, time.Wednesday,
+		time.Thursday, time.Friday, time.Saturday,
+	},
+	URL: func(date time.Time) string {
+		return fmt.Sprintf("https://herbach.dnsalias.com/wsj/wsj%02d%02d%02d.puz",
+			date.Year()%100, date.Month(), date.Day())
+	},
+}
+
+// SourceRegistry holds the puzzle sources available to rooms. It is safe for
+// concurrent use.
+type SourceRegistry struct {
+	mu      sync.RWMutex
+	sources map[string]Source
+	order   []string
+}
+
+var GlobalSources *SourceRegistry
+
+func NewSourceRegistry(sources ...Source) *SourceRegistry {
+	r := &SourceRegistry{sources: make(map[string]Source)}
+	for _, s := range sources {
+		r.Register(s)
+	}
+	return r
+}
+
+// Register adds a source, replacing any source with the same ID.
+func (r *SourceRegistry) Register(s Source) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.sources[s.ID()]; !ok {
+		r.order = append(r.order, s.ID())
+	}
+	r.sources[s.ID()] = s
+}
+
+func (r *SourceRegistry) Get(id string) (Source, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	s, ok := r.sources[id]
+	return s, ok
+}
+
+// List returns the sources in registration order.
+func (r *SourceRegistry) List() []Source {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	sources := make([]Source, len(r.order))
+	for i, id := range r.order {
+		sources[i] = r.sources[id]
+	}
+	return sources
+}
+
+// puzzleID returns the ID of the puzzle a source published on date.
+func puzzleID(sourceID string, date time.Time) string {
+	return fmt.Sprintf("%v-%v-%v-%v", sourceID, date.Year(), int(date.Month()), date.Day())
+}
+
+// fetchPuzzle returns the puzzle a source published on date, from the cache
+// if possible. key unlocks a scrambled solution.
+func fetchPuzzle(sourceID string, date time.Time, key string) (Puzzle, error) {
+	source, ok := GlobalSources.Get(sourceID)
+	if !ok {
+		return Puzzle{}, fmt.Errorf("%w: %q", ErrUnknownSource, sourceID)
+	}
+	if !source.Publishes(date) {
+		return Puzzle{}, ErrNotPublished
+	}
+	return loadPuzzle(puzzleID(sourceID, date), source.Format(), key, func() ([]byte, error) {
+		return source.Fetch(date)
+	})
+}
+
+// fetchCatalogPuzzle returns a puzzle listed by a catalog, from the cache if
+// possible.
+func fetchCatalogPuzzle(catalog Catalog, id, key string) (Puzzle, error) {
+	return loadPuzzle(id, catalog.Format(), key, func() ([]byte, error) {
+		return catalog.FetchID(id)
+	})
+}
+
+// loadPuzzle returns the cached puzzle with id, or fetches and parses it. A
+// solution that key doesn't unlock is left scrambled, so the puzzle can still
+// be played without checking.
+func loadPuzzle(id, fileFormat, key string, fetch func() ([]byte, error)) (Puzzle, error) {
+	if puzzle, ok := GlobalPuzzleCache.Get(id); ok {
+		if puzzle.Scrambled && key != "" {
+			if err := format.Unlock(&puzzle, key); err != nil {
+				log.Printf("Error unlocking %v: %v", id, err)
+			}
+		}
+		return puzzle, nil
+	}
+	data, err := fetch()
+	if err != nil {
+		return Puzzle{}, err
+	}
+	if fileFormat == "" {
+		fileFormat = format.Detect(data)
+	}
+	puzzle, err := format.Parse(data, id, fileFormat)
+	if err != nil {
+		return Puzzle{}, err
+	}
+	if err := format.Unlock(&puzzle, key); err != nil {
+		log.Printf("Error unlocking %v: %v", id, err)
+	}
+	if err := GlobalPuzzleCache.Put(id, fileFormat, data, puzzle); err != nil {
+		log.Printf("Error caching %v: %v", id, err)
+	}
+	return puzzle, nil
+}
+
+// fetchPuzzleByID returns the puzzle with id, as listed by TagNewPuzzle, from
+// the cache if possible.
+func fetchPuzzleByID(id, key string) (Puzzle, error) {
+	if puzzle, ok := GlobalPuzzleCache.Get(id); ok {
+		return puzzle, nil
+	}
+	for _, source := range GlobalSources.List() {
+		prefix := source.ID() + "-"
+		if !strings.HasPrefix(id, prefix) {
+			continue
+		}
+		if catalog, ok := source.(Catalog); ok {
+			for _, entry := range catalog.Entries() {
+				if entry.ID == id {
+					return fetchCatalogPuzzle(catalog, id, key)
+				}
+			}
+		}
+		date, err := time.Parse("2006-1-2", strings.TrimPrefix(id, prefix))
+		if err == nil {
+			return fetchPuzzle(source.ID(), date, key)
+		}
+	}
+	return Puzzle{}, fmt.Errorf("%w: %q", ErrNotFound, id)
+}
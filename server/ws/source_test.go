@@ -0,0 +1,133 @@
+package ws
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tmngo/crossword-server/format"
+)
+
+// TestURLSource fetches puzzles from a local stand-in for a source that
+// publishes wsj.puz on Saturdays only.
+func TestURLSource(t *testing.T) {
+	want := setupGlobals(t)
+	data, err := ioutil.ReadFile("../format/testdata/wsj.puz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.URL.Path != "/2021-07-31.puz" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	source := &URLSource{
+		SourceID:   "stand-in",
+		SourceName: "Stand-in",
+		FileFormat: format.Puz,
+		Days:       []time.Weekday{time.Saturday},
+		URL: func(date time.Time) string {
+			return server.URL + date.Format("/2006-01-02.puz")
+		},
+		Client: server.Client(),
+	}
+	GlobalSources.Register(source)
+
+	saturday := time.Date(2021, 7, 31, 0, 0, 0, 0, time.UTC)
+	if _, err := source.Fetch(saturday.AddDate(0, 0, 7)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing puzzle: got %v, want %v", err, ErrNotFound)
+	}
+	if _, err := fetchPuzzle(source.ID(), saturday.AddDate(0, 0, 7), ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing puzzle: got %v, want %v", err, ErrNotFound)
+	}
+
+	before := atomic.LoadInt32(&requests)
+	if _, err := fetchPuzzle(source.ID(), saturday.AddDate(0, 0, 2), ""); !errors.Is(err, ErrNotPublished) {
+		t.Errorf("Monday: got %v, want %v", err, ErrNotPublished)
+	}
+	if atomic.LoadInt32(&requests) != before {
+		t.Error("Monday's puzzle was requested from the source")
+	}
+
+	id := puzzleID(source.ID(), saturday)
+	// The cache is shared, so drop the puzzle a previous run left in it.
+	GlobalPuzzleCache.Delete(id)
+	for i := 0; i < 2; i++ {
+		got, err := fetchPuzzleByID(id, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != id || got.Grid != want.Grid {
+			t.Errorf("fetched %q with grid %q, want %q with grid %q", got.ID, got.Grid, id, want.Grid)
+		}
+	}
+	// The second fetch is served from the cache.
+	if n := atomic.LoadInt32(&requests) - before; n != 1 {
+		t.Errorf("source was requested %d times, want 1", n)
+	}
+
+	if _, err := fetchPuzzle("missing", saturday, ""); !errors.Is(err, ErrUnknownSource) {
+		t.Errorf("unknown source: got %v, want %v", err, ErrUnknownSource)
+	}
+	if _, err := fetchPuzzleByID("missing-2021-7-31", ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown ID: got %v, want %v", err, ErrNotFound)
+	}
+}
+
+// TestLockedSource checks that a puzzle whose solution can't be unlocked is
+// still loaded, and is unlocked once the right key is given.
+func TestLockedSource(t *testing.T) {
+	want := setupGlobals(t)
+	locked := want
+	// Another key also matches this solution's checksum, so it can only be
+	// unlocked with its own key.
+	if err := format.Lock(&locked, 2295); err != nil {
+		t.Fatal(err)
+	}
+	data, err := format.WritePuz(locked, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	defer server.Close()
+	source := &URLSource{
+		SourceID:   "locked",
+		SourceName: "Locked",
+		FileFormat: format.Puz,
+		URL: func(date time.Time) string {
+			return server.URL + date.Format("/2006-01-02.puz")
+		},
+		Client: server.Client(),
+	}
+	GlobalSources.Register(source)
+
+	date := time.Date(2021, 7, 31, 0, 0, 0, 0, time.UTC)
+	for _, key := range []string{"", "1234"} {
+		got, err := fetchPuzzle(source.ID(), date, key)
+		if err != nil {
+			t.Fatalf("key %q: %v", key, err)
+		}
+		if !got.Scrambled || got.HasSolution() {
+			t.Errorf("key %q: puzzle was unlocked", key)
+		}
+	}
+	got, err := fetchPuzzle(source.ID(), date, "2295")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Scrambled || got.Grid != want.Grid {
+		t.Errorf("grid is %q, want %q", got.Grid, want.Grid)
+	}
+}
@@ -0,0 +1,114 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// PlayerStats counts a player's entries on the active puzzle.
+type PlayerStats struct {
+	// Letters is the number of entries the player made, including ones
+	// later changed.
+	Letters int `json:"letters"`
+	// CorrectFirstTry is the number of cells whose first entry was the
+	// player's and was correct.
+	CorrectFirstTry int `json:"correctFirstTry"`
+	// WordsCompleted is the number of words correctly finished by one of
+	// the player's entries. Each word is credited only the first time it is
+	// finished.
+	WordsCompleted int `json:"wordsCompleted"`
+	// Revealed is the number of cells the player revealed, including ones
+	// whose reveal was undone.
+	Revealed int `json:"revealed"`
+}
+
+// playerStats returns a player's stats, adding them if needed.
+func (r *Room) playerStats(id string) *PlayerStats {
+	stats := r.stats[id]
+	if stats == nil {
+		stats = &PlayerStats{}
+		r.stats[id] = stats
+	}
+	return stats
+}
+
+// recordEntry credits a player with an entry in a cell. firstTry is set if
+// no one had entered the cell before, and wasCorrect if the cell's previous
+// entry was already correct.
+func (r *Room) recordEntry(id string, index int, firstTry, wasCorrect bool) {
+	stats := r.playerStats(id)
+	stats.Letters++
+	if !r.puzzle.HasSolution() || wasCorrect || !r.isCorrect(index) {
+		return
+	}
+	if firstTry {
+		stats.CorrectFirstTry++
+	}
+	row, col := index/r.width, index%r.width
+	for _, dir := range []Direction{Across, Down} {
+		i, ok := r.clueAt(Position{row, col, dir})
+		if !ok || i >= len(r.completedWords) || r.completedWords[i] {
+			continue
+		}
+		if cells := r.clueCells(i); len(cells) > 1 && r.wordCorrect(cells) {
+			r.completedWords[i] = true
+			stats.WordsCompleted++
+		}
+	}
+}
+
+// wordCorrect reports whether every cell of a word has its correct entry.
+func (r *Room) wordCorrect(cells []int) bool {
+	for _, index := range cells {
+		if !r.isCorrect(index) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Subscription) handleSummary() error {
+	return s.actor.call(func(room *Room) error {
+		if room.puzzle.Grid == "" {
+			return errors.New("No puzzle is loaded.")
+		}
+		return room.send(s.client, TagSummary, room.completion())
+	})
+}
+
+// ServeSummary serves the solve summary of the room at /summary/<room> as
+// JSON.
+func ServeSummary(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	roomName := "/ws/" + strings.TrimPrefix(r.URL.Path, "/summary/")
+	room := hub.Room(roomName)
+	if room == nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	var data []byte
+	err := room.call(func(room *Room) error {
+		if room.puzzle.Grid == "" {
+			return ErrNotFound
+		}
+		var err error
+		data, err = json.Marshal(room.completion())
+		return err
+	})
+	if err == ErrNotFound || err == ErrRoomClosed {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Error summarizing %v: %v", roomName, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
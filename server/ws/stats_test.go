@@ -0,0 +1,51 @@
+package ws
+
+import "testing"
+
+// TestWordsCompleted checks that a word is credited to the player who
+// finishes it only the first time, and that the summary serves who entered
+// each cell and when.
+func TestWordsCompleted(t *testing.T) {
+	r := newRoom("stats")
+	r.setPuzzle(testPuzzle("stats", "AB.CD", 5, 1))
+	ann := &Player{ID: "ann"}
+	bob := &Player{ID: "bob"}
+	steps := []struct {
+		player *Player
+		col    int
+		value  string
+	}{
+		{ann, 0, "A"},
+		{bob, 1, "B"}, // Finishes AB.
+		{bob, 1, ""},
+		{ann, 1, "B"}, // Finishes AB again.
+		{ann, 3, "C"},
+		{ann, 4, "X"},
+		{ann, 4, "D"}, // Finishes CD.
+	}
+	for _, step := range steps {
+		r.setCellValue(0, step.col, step.value, step.player)
+	}
+	tests := []struct {
+		player *Player
+		words  int
+	}{
+		{ann, 1},
+		{bob, 1},
+	}
+	for _, test := range tests {
+		if got := r.playerStats(test.player.ID).WordsCompleted; got != test.words {
+			t.Errorf("%v completed %d words, want %d", test.player.ID, got, test.words)
+		}
+	}
+
+	entries := r.completion().Entries
+	if len(entries) != 4 {
+		t.Fatalf("got %d entries, want 4: %+v", len(entries), entries)
+	}
+	for _, entry := range entries {
+		if entry.PlayerID != "ann" || entry.EnteredAt.IsZero() {
+			t.Errorf("cell %d was entered by %q at %v, want ann", entry.Index, entry.PlayerID, entry.EnteredAt)
+		}
+	}
+}
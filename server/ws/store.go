@@ -0,0 +1,242 @@
+package ws
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// persistInterval is how often changed rooms are written to the store.
+const persistInterval = 15 * time.Second
+
+// maxChatHistory is the number of chat messages a room keeps.
+const maxChatHistory = 100
+
+// roomSnapshot is the saved form of a room.
+type roomSnapshot struct {
+	PuzzleID string      `json:"puzzleId"`
+	State    []string    `json:"state"`
+	Flags    []CellFlags `json:"flags"`
+	Authors  []string    `json:"authors,omitempty"`
+	// EnteredAt and Stats are missing from rooms saved before they were
+	// recorded.
+	EnteredAt      []time.Time             `json:"enteredAt,omitempty"`
+	Stats          map[string]*PlayerStats `json:"stats,omitempty"`
+	CompletedWords []bool                  `json:"completedWords,omitempty"`
+	// Elapsed is the solving time in seconds.
+	Elapsed int  `json:"elapsed"`
+	Solved  bool `json:"solved"`
+	// Players holds connected and departed players, by ID.
+	Players map[string]*Player          `json:"players"`
+	Chat    []string                    `json:"chat"`
+	History map[string]progressSnapshot `json:"history,omitempty"`
+	// Sessions lets players resume after a restart, within the grace
+	// window. It is keyed by token hash, so the file holds no credentials.
+	Sessions map[string]*session `json:"sessions,omitempty"`
+	Settings RoomSettings        `json:"settings"`
+	SavedAt  time.Time           `json:"savedAt"`
+}
+
+// progressSnapshot is the saved form of a puzzleProgress.
+type progressSnapshot struct {
+	State          []string                `json:"state"`
+	Flags          []CellFlags             `json:"flags"`
+	Authors        []string                `json:"authors,omitempty"`
+	EnteredAt      []time.Time             `json:"enteredAt,omitempty"`
+	Stats          map[string]*PlayerStats `json:"stats,omitempty"`
+	CompletedWords []bool                  `json:"completedWords,omitempty"`
+	Elapsed        int                     `json:"elapsed"`
+	Solved         bool                    `json:"solved"`
+}
+
+// RoomStore saves room snapshots as JSON files in a directory.
+type RoomStore struct {
+	dir string
+}
+
+var GlobalRoomStore *RoomStore
+
+// NewRoomStore opens the store in dir, creating it if needed.
+func NewRoomStore(dir string) (*RoomStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &RoomStore{dir: dir}, nil
+}
+
+func (s *RoomStore) path(name string) string {
+	return filepath.Join(s.dir, url.PathEscape(name)+".json")
+}
+
+// Save writes the snapshot of the room with name.
+func (s *RoomStore) Save(name string, snapshot []byte) error {
+	return writeFileAtomic(s.path(name), snapshot)
+}
+
+// Load reads the snapshot of the room with name. The error satisfies
+// os.IsNotExist if the room was never saved.
+func (s *RoomStore) Load(name string) (roomSnapshot, error) {
+	var snapshot roomSnapshot
+	data, err := ioutil.ReadFile(s.path(name))
+	if err != nil {
+		return snapshot, err
+	}
+	err = json.Unmarshal(data, &snapshot)
+	return snapshot, err
+}
+
+// snapshot returns the saved form of the room.
+func (r *Room) snapshot() roomSnapshot {
+	players := make(map[string]*Player, len(r.players)+len(r.departed))
+	for id, player := range r.departed {
+		players[id] = player
+	}
+	for id, player := range r.players {
+		players[id] = player
+	}
+	history := make(map[string]progressSnapshot, len(r.history))
+	for id, saved := range r.history {
+		history[id] = progressSnapshot{
+			State:          saved.state,
+			Flags:          saved.flags,
+			Authors:        saved.authors,
+			EnteredAt:      saved.enteredAt,
+			Stats:          saved.stats,
+			CompletedWords: saved.completedWords,
+			Elapsed:        int(saved.elapsed.Seconds()),
+			Solved:         saved.solved,
+		}
+	}
+	return roomSnapshot{
+		PuzzleID:       r.puzzle.ID,
+		State:          r.state,
+		Flags:          r.flags,
+		Authors:        r.authors,
+		EnteredAt:      r.enteredAt,
+		Stats:          r.stats,
+		CompletedWords: r.completedWords,
+		Elapsed:        int(r.elapsed().Seconds()),
+		Solved:         r.solved,
+		Players:        players,
+		Chat:           r.chat,
+		History:        history,
+		Sessions:       r.sessions,
+		Settings:       r.settings,
+		SavedAt:        time.Now(),
+	}
+}
+
+// restore loads the room's snapshot from the store, if it was saved. It is
+// run on the room's goroutine before its commands, so a slow puzzle fetch
+// only holds up this room.
+func (r *Room) restore() {
+	if r.previous != nil {
+		// Wait for an earlier room with this name to be saved.
+		<-r.previous
+	}
+	if r.store == nil {
+		return
+	}
+	snapshot, err := r.store.Load(r.name)
+	if os.IsNotExist(err) {
+		return
+	} else if err != nil {
+		log.Printf("Error loading room %v: %v", r.name, err)
+		return
+	}
+	r.restoreSnapshot(snapshot)
+	log.Printf("Restored room %v.", r.name)
+}
+
+// restoreSnapshot rebuilds the room from a snapshot. Its players are departed
+// until they reconnect. If the active puzzle can't be fetched, its work is
+// kept in the history so that it isn't lost when the room is next saved.
+func (r *Room) restoreSnapshot(snapshot roomSnapshot) {
+	r.chat = snapshot.Chat
+	r.settings = snapshot.Settings
+	r.history = make(map[string]*puzzleProgress)
+	for id, player := range snapshot.Players {
+		r.departed[id] = player
+	}
+	for token, s := range snapshot.Sessions {
+		// The event log is not saved, and connected players left when the
+		// snapshot was taken.
+		s.LeftPos = 0
+		if s.LeftAt.IsZero() {
+			s.LeftAt = snapshot.SavedAt
+		}
+		r.sessions[token] = s
+	}
+	r.expireSessions()
+	for id, saved := range snapshot.History {
+		r.history[id] = &puzzleProgress{
+			state:          saved.State,
+			flags:          saved.Flags,
+			authors:        saved.Authors,
+			enteredAt:      saved.EnteredAt,
+			stats:          saved.Stats,
+			completedWords: saved.CompletedWords,
+			elapsed:        time.Duration(saved.Elapsed) * time.Second,
+			solved:         saved.Solved,
+		}
+	}
+	if snapshot.PuzzleID == "" {
+		return
+	}
+	// Restore the active puzzle through the history, like any other puzzle
+	// the room has worked on.
+	r.history[snapshot.PuzzleID] = &puzzleProgress{
+		state:          snapshot.State,
+		flags:          snapshot.Flags,
+		authors:        snapshot.Authors,
+		enteredAt:      snapshot.EnteredAt,
+		stats:          snapshot.Stats,
+		completedWords: snapshot.CompletedWords,
+		elapsed:        time.Duration(snapshot.Elapsed) * time.Second,
+		solved:         snapshot.Solved,
+	}
+	puzzle, err := fetchPuzzleByID(snapshot.PuzzleID, "")
+	if err != nil {
+		log.Printf("Error restoring puzzle %v in room %v: %v", snapshot.PuzzleID, r.name, err)
+		return
+	}
+	r.setPuzzle(puzzle)
+	delete(r.history, puzzle.ID)
+}
+
+// persist saves the room if it changed since it was last saved.
+func (r *Room) persist() {
+	if r.store == nil || (r.puzzle.ID == "" && len(r.chat) == 0 && len(r.history) == 0) {
+		return
+	}
+	snapshot := r.snapshot()
+	// Compare without the timestamps, which change on every call.
+	snapshot.SavedAt = time.Time{}
+	elapsed := snapshot.Elapsed
+	snapshot.Elapsed = 0
+	key, err := json.Marshal(snapshot)
+	if err != nil {
+		log.Printf("Error saving room %v: %v", r.name, err)
+		return
+	}
+	if bytes.Equal(key, r.saved) {
+		return
+	}
+	snapshot.SavedAt = time.Now()
+	snapshot.Elapsed = elapsed
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		log.Printf("Error saving room %v: %v", r.name, err)
+		return
+	}
+	if err := r.store.Save(r.name, data); err != nil {
+		log.Printf("Error saving room %v: %v", r.name, err)
+		return
+	}
+	r.saved = key
+}
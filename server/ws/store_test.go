@@ -0,0 +1,108 @@
+package ws
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// TestRestoreMissingPuzzle checks that a room whose active puzzle can't be
+// fetched keeps its saved work, and doesn't lose it when saved again.
+func TestRestoreMissingPuzzle(t *testing.T) {
+	setupGlobals(t)
+	store, err := NewRoomStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saved := roomSnapshot{
+		PuzzleID: "gone-2021-7-31",
+		State:    []string{"A", "", "C"},
+		Flags:    make([]CellFlags, 3),
+		Elapsed:  42,
+		Players:  map[string]*Player{"p1": {ID: "p1", Name: "Ann"}},
+		Chat:     []string{"hello"},
+		History: map[string]progressSnapshot{
+			"older": {State: []string{"X"}, Flags: make([]CellFlags, 1)},
+		},
+	}
+	data, err := json.Marshal(saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save("/ws/room", data); err != nil {
+		t.Fatal(err)
+	}
+
+	r := newRoom("/ws/room")
+	r.store = store
+	r.restore()
+	if r.puzzle.ID != "" {
+		t.Errorf("active puzzle is %q, want none", r.puzzle.ID)
+	}
+	if len(r.chat) != 1 || r.departed["p1"] == nil || r.history["older"] == nil {
+		t.Fatalf("room lost its chat, players or history: %+v", r.snapshot())
+	}
+	if progress := r.history[saved.PuzzleID]; progress == nil || progress.state[0] != "A" {
+		t.Fatalf("active puzzle's work was not kept: %+v", progress)
+	}
+
+	r.persist()
+	resaved, err := store.Load("/ws/room")
+	if err != nil {
+		t.Fatal(err)
+	}
+	progress, ok := resaved.History[saved.PuzzleID]
+	if !ok || progress.State[2] != "C" || progress.Elapsed != 42 {
+		t.Errorf("saved history lost the active puzzle's work: %+v", resaved.History)
+	}
+	if len(resaved.Chat) != 1 || resaved.Players["p1"] == nil {
+		t.Errorf("saved room lost its chat or players: %+v", resaved)
+	}
+}
+
+// TestSessionTokenHashed checks that a saved room holds no session tokens,
+// and that a token still resumes its player once the room is restored.
+func TestSessionTokenHashed(t *testing.T) {
+	setupGlobals(t)
+	store, err := NewRoomStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newRoom("/ws/tokens")
+	r.store = store
+	r.setPuzzle(testPuzzle("tokens", "AB", 2, 1))
+	client := &Client{id: "ann", send: make(chan []byte, 64)}
+	r.join(client)
+	var token string
+	for message := range drainClient(client) {
+		var msg Message
+		json.Unmarshal(message, &msg)
+		if msg.Tag == TagRegister {
+			var register Register
+			json.Unmarshal(msg.Data, &register)
+			token = register.Token
+		}
+	}
+	r.leave(client)
+	r.persist()
+
+	data, err := ioutil.ReadFile(store.path("/ws/tokens"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token == "" || strings.Contains(string(data), token) {
+		t.Fatalf("saved room holds the session token %q", token)
+	}
+
+	restored := newRoom("/ws/tokens")
+	restored.store = store
+	restored.restore()
+	resumed := &Client{id: "new", send: make(chan []byte, 64)}
+	resumed.resume = resumeRequest{token: token}
+	restored.join(resumed)
+	if resumed.id != "ann" {
+		t.Errorf("token resumed %q, want ann", resumed.id)
+	}
+}
@@ -0,0 +1,153 @@
+package ws
+
+import (
+	"encoding/json"
+	"log"
+)
+
+// SyncRequest asks for a full snapshot of the room. Clients that set Deltas
+// are sent a Delta for each change instead of a full PlayerUpdate.
+type SyncRequest struct {
+	Deltas bool `json:"deltas"`
+}
+
+// Snapshot is the room's full state as of Seq.
+type Snapshot struct {
+	Seq uint64 `json:"seq"`
+	PlayerUpdate
+	Timer TimerState `json:"timer"`
+}
+
+// CellDelta is the new entry and marks of one cell.
+type CellDelta struct {
+	Index int       `json:"index"`
+	Value string    `json:"value"`
+	Flags CellFlags `json:"flags"`
+}
+
+// Delta holds the changes that took the room from sequence number Seq-1 to
+// Seq. A client that sees a gap in the sequence should send a SyncRequest.
+//
+// Checks and reveals change cell flags, so they are sent in deltas. Timer,
+// settings, completion and "not quite" messages are not sequenced. A
+// connection never skips a message, since a client that falls behind is
+// dropped instead. Timer and settings messages carry their whole state, and
+// a resumed client is sent both before its replay, so a missed one is
+// superseded. Completion messages are logged like chat and replayed with it.
+type Delta struct {
+	Seq     uint64             `json:"seq"`
+	Cells   []CellDelta        `json:"cells,omitempty"`
+	Players map[string]*Player `json:"players,omitempty"`
+	// Left holds the IDs of players who left the room.
+	Left []string `json:"left,omitempty"`
+}
+
+func (s *Subscription) handleSync(input json.RawMessage) error {
+	var request SyncRequest
+	if err := json.Unmarshal([]byte(input), &request); err != nil {
+		return err
+	}
+	return s.actor.call(func(room *Room) error {
+		if request.Deltas {
+			room.deltaClients[s.client] = true
+		} else {
+			delete(room.deltaClients, s.client)
+		}
+		return room.send(s.client, TagSnapshot, room.stateSnapshot())
+	})
+}
+
+func (r *Room) stateSnapshot() Snapshot {
+	return Snapshot{r.seq, r.playerUpdate(), r.timerState()}
+}
+
+// markCell records a changed cell for the next flush.
+func (r *Room) markCell(index int) {
+	if r.changedCells == nil {
+		r.changedCells = make(map[int]bool)
+	}
+	r.changedCells[index] = true
+}
+
+// markPlayer records a changed or departed player for the next flush.
+func (r *Room) markPlayer(id string) {
+	if r.changedPlayers == nil {
+		r.changedPlayers = make(map[string]bool)
+	}
+	r.changedPlayers[id] = true
+}
+
+// flush sends the changes marked since the last flush. Clients that asked
+// for deltas get a Delta; the rest get a full PlayerUpdate.
+func (r *Room) flush() {
+	if len(r.changedCells) == 0 && len(r.changedPlayers) == 0 {
+		return
+	}
+	if len(r.changedCells) > 0 {
+		// Announce a finished grid after the change that finished it.
+		defer r.checkCompletion()
+	}
+	r.seq++
+	delta := Delta{Seq: r.seq}
+	for index := range r.changedCells {
+		if index < len(r.state) {
+			delta.Cells = append(delta.Cells, CellDelta{index, r.state[index], r.flags[index]})
+		}
+	}
+	for id := range r.changedPlayers {
+		if player, ok := r.players[id]; ok {
+			if delta.Players == nil {
+				delta.Players = make(map[string]*Player)
+			}
+			delta.Players[id] = player
+		} else {
+			delta.Left = append(delta.Left, id)
+		}
+	}
+	r.changedCells = nil
+	r.changedPlayers = nil
+
+	deltaMessage, err := r.logMessage(r.seq, TagDelta, delta)
+	if err != nil {
+		return
+	}
+	var updateMessage []byte
+	for client := range r.clients {
+		if r.deltaClients[client] {
+			r.sendMessage(client, deltaMessage)
+			continue
+		}
+		if updateMessage == nil {
+			updateMessage, err = json.Marshal(TaggedMessage{TagPlayerUpdate, r.playerUpdate()})
+			if err != nil {
+				log.Printf("Error encoding update: %v", err)
+				return
+			}
+		}
+		r.sendMessage(client, updateMessage)
+	}
+}
+
+// broadcastState sends the room's full state to every client, after a
+// change too large for a delta such as a new puzzle.
+func (r *Room) broadcastState() error {
+	r.changedCells = nil
+	r.changedPlayers = nil
+	r.seq++
+	snapshot, err := r.logMessage(r.seq, TagSnapshot, r.stateSnapshot())
+	if err != nil {
+		return err
+	}
+	update, err := json.Marshal(TaggedMessage{TagPlayerUpdate, r.playerUpdate()})
+	if err != nil {
+		return err
+	}
+	for client := range r.clients {
+		if r.deltaClients[client] {
+			r.sendMessage(client, snapshot)
+		} else {
+			r.sendMessage(client, update)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package ws
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// received sorts the messages queued for a client into its deltas and the
+// tags of every message, in order.
+func received(client *Client) ([]Delta, []MessageTag) {
+	var deltas []Delta
+	var tags []MessageTag
+	for message := range drainClient(client) {
+		var msg Message
+		json.Unmarshal(message, &msg)
+		tags = append(tags, msg.Tag)
+		if msg.Tag == TagDelta {
+			var delta Delta
+			json.Unmarshal(msg.Data, &delta)
+			deltas = append(deltas, delta)
+		}
+	}
+	return deltas, tags
+}
+
+// checkSequence reports an error unless deltas are numbered from first to
+// last without gaps.
+func checkSequence(t *testing.T, deltas []Delta, first, last uint64) {
+	t.Helper()
+	want := first
+	for _, delta := range deltas {
+		if delta.Seq != want {
+			t.Errorf("delta %d follows %d", delta.Seq, want-1)
+		}
+		want = delta.Seq + 1
+	}
+	if want != last+1 {
+		t.Errorf("deltas end at %d, want %d", want-1, last)
+	}
+}
+
+// TestDeltaSequence checks that every change is sent in a numbered delta,
+// including the flags set by another player's check, with timer and settings
+// messages in between not taking up sequence numbers.
+func TestDeltaSequence(t *testing.T) {
+	r := newRoom("seq")
+	r.setPuzzle(testPuzzle("seq", "ABCD", 4, 1))
+	watcher := &Client{id: "watcher", send: make(chan []byte, 256)}
+	typist := &Client{id: "typist", send: make(chan []byte, 256)}
+	r.join(watcher)
+	r.join(typist)
+	r.deltaClients[watcher] = true
+	drainClient(watcher)
+	start := r.seq
+
+	player := r.players["typist"]
+	r.handlePlayerAction(player, "x")
+	r.flush()
+	r.broadcast(TagSettings, r.settings)
+	r.check(player, CheckRequest{ActionCheck, ScopePuzzle})
+	r.flush()
+	r.pauseTimer(r.lastActivity, PausedByPlayer)
+
+	deltas, tags := received(watcher)
+	checkSequence(t, deltas, start+1, r.seq)
+	if countOf(tags, TagTimer) != 2 || countOf(tags, TagSettings) != 1 {
+		t.Errorf("got messages %v, want two timers and one settings", tags)
+	}
+	var value string
+	var flags CellFlags
+	for _, delta := range deltas {
+		for _, cell := range delta.Cells {
+			if cell.Index == 0 {
+				value, flags = cell.Value, cell.Flags
+			}
+		}
+	}
+	if value != "X" || flags&FlagIncorrect == 0 {
+		t.Errorf("cell 0 is %q with flags %#x, want X marked incorrect", value, flags)
+	}
+}
+
+// TestResumeReplay checks what a player resuming in delta mode is sent: the
+// deltas and completion they missed, in order, or a snapshot when the room's
+// log no longer reaches back to their last delta.
+func TestResumeReplay(t *testing.T) {
+	tests := []struct {
+		name string
+		// keep is the number of log events kept before resuming, or zero
+		// to keep them all.
+		keep     int
+		snapshot bool
+	}{
+		{"replay", 0, false},
+		{"log trimmed", 1, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newRoom("resume")
+			r.setPuzzle(testPuzzle("resume", "ABC", 3, 1))
+			first := &Client{id: "first", send: make(chan []byte, 256)}
+			r.join(first)
+			var token string
+			for message := range drainClient(first) {
+				var msg Message
+				json.Unmarshal(message, &msg)
+				if msg.Tag == TagRegister {
+					var register Register
+					json.Unmarshal(msg.Data, &register)
+					token = register.Token
+				}
+			}
+			left := r.seq
+			r.leave(first)
+
+			other := &Client{id: "other", send: make(chan []byte, 256)}
+			r.join(other)
+			player := r.players["other"]
+			for _, key := range []string{"a", "b", "c"} {
+				r.handlePlayerAction(player, key)
+				r.flush()
+			}
+			if !r.solved {
+				t.Fatal("grid was not solved")
+			}
+			if test.keep > 0 {
+				r.events = r.events[len(r.events)-test.keep:]
+			}
+
+			resumed := &Client{id: "resumed", send: make(chan []byte, 256)}
+			resumed.resume = resumeRequest{token: token, seq: left, hasSeq: true}
+			r.join(resumed)
+			if resumed.id != "first" {
+				t.Fatalf("resumed as %v, want first", resumed.id)
+			}
+			deltas, tags := received(resumed)
+			if got := countOf(tags, TagSnapshot) > 0; got != test.snapshot {
+				t.Fatalf("snapshot sent: %v, want %v (messages %v)", got, test.snapshot, tags)
+			}
+			if indexOf(tags, TagTimer) > indexOf(tags, TagDelta) || indexOf(tags, TagSettings) > indexOf(tags, TagDelta) {
+				t.Errorf("timer and settings were not sent before the replay: %v", tags)
+			}
+			if test.snapshot {
+				return
+			}
+			// The last delta announces the resumed player's return.
+			checkSequence(t, deltas, left+1, r.seq)
+			if countOf(tags, TagComplete) != 1 {
+				t.Errorf("completion was not replayed: %v", tags)
+			}
+		})
+	}
+}
+
+func countOf(tags []MessageTag, tag MessageTag) int {
+	n := 0
+	for _, t := range tags {
+		if t == tag {
+			n++
+		}
+	}
+	return n
+}
+
+// indexOf returns the position of the first tag in tags, or len(tags).
+func indexOf(tags []MessageTag, tag MessageTag) int {
+	for i, t := range tags {
+		if t == tag {
+			return i
+		}
+	}
+	return len(tags)
+}
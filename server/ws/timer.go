@@ -0,0 +1,184 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	// idleTimeout is how long a room may go without activity before its
+	// timer pauses itself.
+	idleTimeout = 2 * time.Minute
+
+	// idleCheckInterval is how often rooms look for idleness.
+	idleCheckInterval = 5 * time.Second
+)
+
+// Timer actions sent by clients, and the reasons a timer is paused.
+const (
+	TimerPause  = "pause"
+	TimerResume = "resume"
+
+	PausedByPlayer = "player"
+	PausedIdle     = "idle"
+	PausedSolved   = "solved"
+)
+
+// TimerRequest pauses or resumes the room's timer.
+type TimerRequest struct {
+	Action string `json:"action"`
+}
+
+// TimerState is the room's timer as of when it was sent. Clients count up
+// from Elapsed while Running is set.
+type TimerState struct {
+	// Elapsed is the solving time in milliseconds.
+	Elapsed int64 `json:"elapsed"`
+	Running bool  `json:"running"`
+	// Reason says why a stopped timer was paused. It is empty before the
+	// first entry.
+	Reason string `json:"reason,omitempty"`
+}
+
+// roomTimer counts solving time across pauses.
+type roomTimer struct {
+	// elapsed is the time counted before the current run.
+	elapsed time.Duration
+	// startedAt is when the current run began, or zero while paused.
+	startedAt time.Time
+	reason    string
+}
+
+func (t *roomTimer) running() bool {
+	return !t.startedAt.IsZero()
+}
+
+// total returns the time counted up to now.
+func (t *roomTimer) total(now time.Time) time.Duration {
+	if !t.running() {
+		return t.elapsed
+	}
+	return t.elapsed + now.Sub(t.startedAt)
+}
+
+// start runs the timer, reporting whether it was paused.
+func (t *roomTimer) start(now time.Time) bool {
+	if t.running() {
+		return false
+	}
+	t.startedAt = now
+	t.reason = ""
+	return true
+}
+
+// pause stops the timer as of at, reporting whether it was running.
+func (t *roomTimer) pause(at time.Time, reason string) bool {
+	if !t.running() {
+		return false
+	}
+	if at.Before(t.startedAt) {
+		at = t.startedAt
+	}
+	t.elapsed += at.Sub(t.startedAt)
+	t.startedAt = time.Time{}
+	t.reason = reason
+	return true
+}
+
+func (r *Room) timerState() TimerState {
+	return TimerState{
+		Elapsed: r.timer.total(time.Now()).Milliseconds(),
+		Running: r.timer.running(),
+		Reason:  r.timer.reason,
+	}
+}
+
+// startTimer runs the room's timer on an edit. The first entry starts it, and
+// an edit resumes it if it paused itself while the room was idle; a timer a
+// player paused stays paused.
+func (r *Room) startTimer() {
+	r.lastActivity = time.Now()
+	if r.timer.reason != PausedByPlayer {
+		r.resumeTimer()
+	}
+}
+
+// noteActivity records a player's activity other than an edit, such as a
+// cursor move, resuming the timer if it paused itself while the room was idle.
+func (r *Room) noteActivity() {
+	r.lastActivity = time.Now()
+	if r.timer.reason == PausedIdle {
+		r.resumeTimer()
+	}
+}
+
+// resumeTimer runs the room's timer, unless the grid is solved.
+func (r *Room) resumeTimer() {
+	r.lastActivity = time.Now()
+	if !r.solved && r.timer.start(r.lastActivity) {
+		r.broadcast(TagTimer, r.timerState())
+	}
+}
+
+// pauseTimer stops the room's timer as of at.
+func (r *Room) pauseTimer(at time.Time, reason string) {
+	if r.timer.pause(at, reason) {
+		r.broadcast(TagTimer, r.timerState())
+	}
+}
+
+// loadTimer sends the timer of a newly loaded puzzle, starting it first if
+// the room is set to start timers on load.
+func (r *Room) loadTimer() error {
+	if r.settings.StartTimerOnLoad && !r.solved {
+		r.lastActivity = time.Now()
+		r.timer.start(r.lastActivity)
+	}
+	return r.broadcast(TagTimer, r.timerState())
+}
+
+// syncTimer resends a running timer so clients don't drift.
+func (r *Room) syncTimer() {
+	if r.timer.running() {
+		r.broadcast(TagTimer, r.timerState())
+	}
+}
+
+// checkIdle pauses the timer once no one has done anything for idleTimeout,
+// including when everyone has left. The time since the last activity is not
+// counted.
+func (r *Room) checkIdle() {
+	if r.timer.running() && time.Since(r.lastActivity) >= idleTimeout {
+		r.pauseTimer(r.lastActivity, PausedIdle)
+	}
+}
+
+func (s *Subscription) handleTimer(input json.RawMessage) error {
+	var request TimerRequest
+	if err := json.Unmarshal([]byte(input), &request); err != nil {
+		return err
+	}
+	return s.actor.call(func(room *Room) error {
+		if room.puzzle.Grid == "" {
+			return fmt.Errorf("No puzzle is loaded.")
+		}
+		switch request.Action {
+		case TimerPause:
+			room.pauseTimer(time.Now(), PausedByPlayer)
+		case TimerResume:
+			room.resumeTimer()
+		default:
+			return fmt.Errorf("Unknown timer action %q.", request.Action)
+		}
+		return nil
+	})
+}
+
+// ltimTimer returns the timer in the form stored in a .puz LTIM section.
+func (r *Room) ltimTimer() *PuzzleTimer {
+	return &PuzzleTimer{
+		Elapsed: int(r.elapsed().Seconds()),
+		Stopped: !r.timer.running(),
+	}
+}
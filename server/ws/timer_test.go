@@ -0,0 +1,71 @@
+package ws
+
+import (
+	"testing"
+	"time"
+)
+
+// TestTimerActivity checks which activity restarts a stopped timer: an edit
+// starts a fresh timer and resumes one that paused itself while idle, a
+// cursor move only resumes an idle one, and neither overrides a player's
+// pause or a solved grid.
+func TestTimerActivity(t *testing.T) {
+	type activity func(r *Room, player *Player)
+	edit := func(r *Room, player *Player) { r.writeCell(0, "A", false, player.ID) }
+	move := func(r *Room, player *Player) { r.setPlayerPosition(player, 0, 1, Across) }
+	resume := func(r *Room, player *Player) { r.resumeTimer() }
+
+	tests := []struct {
+		name     string
+		reason   string
+		activity activity
+		running  bool
+	}{
+		{"fresh edit", "", edit, true},
+		{"fresh move", "", move, false},
+		{"idle edit", PausedIdle, edit, true},
+		{"idle move", PausedIdle, move, true},
+		{"paused edit", PausedByPlayer, edit, false},
+		{"paused move", PausedByPlayer, move, false},
+		{"paused resume", PausedByPlayer, resume, true},
+		{"solved edit", PausedSolved, edit, false},
+		{"solved resume", PausedSolved, resume, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newRoom("timer")
+			r.setPuzzle(testPuzzle("timer", "ABCD", 4, 1))
+			player := &Player{ID: "a"}
+			if test.reason != "" {
+				start := time.Now().Add(-time.Minute)
+				r.timer.start(start)
+				r.solved = test.reason == PausedSolved
+				r.pauseTimer(start.Add(time.Second), test.reason)
+			}
+			test.activity(r, player)
+			if r.timer.running() != test.running {
+				t.Errorf("timer running: %v, want %v", r.timer.running(), test.running)
+			}
+			if test.reason != "" && r.timer.total(time.Now()) < time.Second {
+				t.Error("time counted before the pause was lost")
+			}
+		})
+	}
+}
+
+// TestTimerIdle checks that an idle room's timer stops as of the last
+// activity, without counting the idle time.
+func TestTimerIdle(t *testing.T) {
+	r := newRoom("idle")
+	r.setPuzzle(testPuzzle("idle", "ABCD", 4, 1))
+	start := time.Now().Add(-time.Hour)
+	r.timer.start(start)
+	r.lastActivity = start.Add(time.Minute)
+	r.checkIdle()
+	if r.timer.running() || r.timer.reason != PausedIdle {
+		t.Fatalf("timer running: %v, reason %q; want paused as idle", r.timer.running(), r.timer.reason)
+	}
+	if got := r.timer.total(time.Now()); got != time.Minute {
+		t.Errorf("counted %v, want %v", got, time.Minute)
+	}
+}
@@ -7,5 +7,14 @@ const (
 	KeyArrowUp    = "ArrowUp"
 	KeyBackspace  = "Backspace"
 	KeyDelete     = "Delete"
+	KeyEnd        = "End"
+	KeyEnter      = "Enter"
+	KeyEscape     = "Escape"
+	KeyHome       = "Home"
+	KeyInsert     = "Insert"
 	KeySpace      = " "
+	KeyTab        = "Tab"
+	// KeyShiftTab is sent for Tab pressed with Shift, which has no key name
+	// of its own.
+	KeyShiftTab = "Shift+Tab"
 )
@@ -0,0 +1,150 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// maxEdits is the number of cell edits a room keeps for undo.
+const maxEdits = 512
+
+// Undo actions and the edits they apply to.
+const (
+	ActionUndo = "undo"
+	ActionRedo = "redo"
+
+	// ScopePlayer undoes the requesting player's own edits, and ScopeRoom
+	// undoes anyone's.
+	ScopePlayer = "player"
+	ScopeRoom   = "room"
+)
+
+// UndoRequest undoes or redoes the most recent edit in its scope.
+type UndoRequest struct {
+	Action string `json:"action"`
+	Scope  string `json:"scope"`
+}
+
+// cellEdit is one change to a cell's entry, with what it replaced.
+type cellEdit struct {
+	Index     int
+	Old       string
+	OldPencil bool
+	// OldAuthor is restored along with Old on undo.
+	OldAuthor string
+	New       string
+	NewPencil bool
+	PlayerID  string
+	At        time.Time
+	// Reveal is set for a reveal, which is undone by restoring the cell's
+	// old flags as well.
+	Reveal   bool
+	OldFlags CellFlags
+}
+
+func (s *Subscription) handleUndo(input json.RawMessage) error {
+	var request UndoRequest
+	if err := json.Unmarshal([]byte(input), &request); err != nil {
+		return err
+	}
+	return s.actor.call(func(room *Room) error {
+		player := room.players[s.client.id]
+		if player == nil {
+			return errors.New("Player is nil.")
+		}
+		err := room.undo(player, request)
+		room.flush()
+		return err
+	})
+}
+
+// logEdit records an edit for undo. A new edit discards the redo history of
+// the player who made it.
+func (r *Room) logEdit(edit cellEdit) {
+	r.edits = append(r.edits, edit)
+	if len(r.edits) > maxEdits {
+		r.edits = r.edits[len(r.edits)-maxEdits:]
+	}
+	undone := r.undone[:0]
+	for _, e := range r.undone {
+		if e.PlayerID != edit.PlayerID {
+			undone = append(undone, e)
+		}
+	}
+	r.undone = undone
+}
+
+// undo undoes or redoes the most recent edit in a request's scope. Edits to
+// cells that someone has changed since are skipped and forgotten.
+func (r *Room) undo(player *Player, request UndoRequest) error {
+	if request.Scope != ScopePlayer && request.Scope != ScopeRoom {
+		return fmt.Errorf("Unknown undo scope %q.", request.Scope)
+	}
+	inScope := func(edit cellEdit) bool {
+		return request.Scope == ScopeRoom || edit.PlayerID == player.ID
+	}
+	switch request.Action {
+	case ActionUndo:
+		var edit cellEdit
+		var ok bool
+		if r.edits, edit, ok = r.popEdit(r.edits, inScope, false); ok {
+			if edit.Reveal {
+				r.unreveal(edit)
+			} else {
+				r.writeCell(edit.Index, edit.Old, edit.OldPencil, edit.OldAuthor)
+			}
+			r.undone = append(r.undone, edit)
+		}
+	case ActionRedo:
+		var edit cellEdit
+		var ok bool
+		if r.undone, edit, ok = r.popEdit(r.undone, inScope, true); ok {
+			if edit.Reveal {
+				r.writeReveal(edit.Index)
+			} else {
+				r.writeCell(edit.Index, edit.New, edit.NewPencil, edit.PlayerID)
+			}
+			r.edits = append(r.edits, edit)
+		}
+	default:
+		return fmt.Errorf("Unknown undo action %q.", request.Action)
+	}
+	return nil
+}
+
+// unreveal undoes a reveal, restoring the cell's entry, author and flags. The
+// revealing player's stats still count the reveal.
+func (r *Room) unreveal(edit cellEdit) {
+	r.state[edit.Index] = edit.Old
+	r.authors[edit.Index] = edit.OldAuthor
+	r.setFlags(edit.Index, edit.OldFlags)
+	r.markCell(edit.Index)
+}
+
+// popEdit removes and returns the last edit in scope from a log, along with
+// any stale edits in scope found after it. An edit is stale if its cell no
+// longer holds its new entry, or its old entry when redoing. Only a reveal
+// can be undone from a revealed cell.
+func (r *Room) popEdit(edits []cellEdit, inScope func(cellEdit) bool, redo bool) ([]cellEdit, cellEdit, bool) {
+	for i := len(edits) - 1; i >= 0; i-- {
+		edit := edits[i]
+		if !inScope(edit) {
+			continue
+		}
+		value, pencil := edit.New, edit.NewPencil
+		if redo {
+			value, pencil = edit.Old, edit.OldPencil
+		}
+		revealed := edit.Reveal && !redo
+		current := edit.Index < len(r.state) && !r.solved &&
+			r.flags[edit.Index]&FlagRevealed != 0 == revealed &&
+			r.state[edit.Index] == value && r.isPencil(edit.Index) == pencil
+		edits = append(edits[:i], edits[i+1:]...)
+		if current {
+			return edits, edit, true
+		}
+	}
+	return edits, cellEdit{}, false
+}
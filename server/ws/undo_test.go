@@ -0,0 +1,81 @@
+package ws
+
+import "testing"
+
+// TestUndoScopes checks which edits undo and redo apply to: a player's own
+// or anyone's, skipping edits to cells changed since, and dropping a
+// player's redo history once they make a new edit.
+func TestUndoScopes(t *testing.T) {
+	// step is an undo request when action is set, and otherwise types key
+	// in column col.
+	type step struct {
+		player string
+		action string
+		scope  string
+		col    int
+		key    string
+	}
+	undo := func(player, action, scope string) step { return step{player, action, scope, 0, ""} }
+	typed := func(player string, col int, key string) step { return step{player, "", "", col, key} }
+
+	tests := []struct {
+		name  string
+		steps []step
+		state string
+		err   bool
+	}{
+		{"own edit", []step{undo("ann", ActionUndo, ScopePlayer)}, " B  ", false},
+		{"anyone's edit", []step{undo("ann", ActionUndo, ScopeRoom)}, "A   ", false},
+		{"twice", []step{undo("ann", ActionUndo, ScopeRoom), undo("ann", ActionUndo, ScopeRoom)}, "    ", false},
+		{"redo", []step{undo("ann", ActionUndo, ScopePlayer), undo("ann", ActionRedo, ScopePlayer)}, "AB  ", false},
+		{"redo another's", []step{undo("ann", ActionUndo, ScopePlayer), undo("bob", ActionRedo, ScopePlayer)}, " B  ", false},
+		{"redo room", []step{undo("ann", ActionUndo, ScopePlayer), undo("bob", ActionRedo, ScopeRoom)}, "AB  ", false},
+		{"changed since", []step{typed("bob", 0, "x"), undo("ann", ActionUndo, ScopePlayer)}, "XB  ", false},
+		{"changed since, then room", []step{
+			typed("bob", 0, "x"),
+			undo("ann", ActionUndo, ScopeRoom),
+			undo("ann", ActionUndo, ScopeRoom),
+			undo("ann", ActionUndo, ScopeRoom),
+		}, "    ", false},
+		{"new edit", []step{
+			undo("ann", ActionUndo, ScopePlayer),
+			typed("ann", 2, "c"),
+			undo("ann", ActionRedo, ScopePlayer),
+		}, " BC ", false},
+		{"other's new edit", []step{
+			undo("ann", ActionUndo, ScopePlayer),
+			typed("bob", 3, "d"),
+			undo("ann", ActionRedo, ScopePlayer),
+		}, "AB D", false},
+		{"unknown scope", []step{undo("ann", ActionUndo, "cell")}, "AB  ", true},
+		{"unknown action", []step{undo("ann", "again", ScopeRoom)}, "AB  ", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newRoom("undo")
+			r.setPuzzle(testPuzzle("undo", "ABCD", 4, 1))
+			for _, id := range []string{"ann", "bob"} {
+				r.join(&Client{id: id, send: make(chan []byte, 256)})
+			}
+			steps := append([]step{typed("ann", 0, "a"), typed("bob", 1, "b")}, test.steps...)
+			failed := false
+			for _, s := range steps {
+				player := r.players[s.player]
+				if s.action == "" {
+					player.Position = Position{0, s.col, Across}
+					r.handlePlayerAction(player, s.key)
+				} else if err := r.undo(player, UndoRequest{s.action, s.scope}); err != nil {
+					failed = true
+				}
+			}
+			if failed != test.err {
+				t.Errorf("got an error: %v, want %v", failed, test.err)
+			}
+			for i, entry := range r.state {
+				if want := string(test.state[i]); entry != want && !(entry == "" && want == " ") {
+					t.Errorf("cell %d holds %q, want %q", i, entry, want)
+				}
+			}
+		})
+	}
+}